@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"github.com/caarlos0/env"
 	"github.com/devtron-labs/source-controller/api"
+	"github.com/devtron-labs/source-controller/common"
+	"github.com/devtron-labs/source-controller/controller"
+	"github.com/devtron-labs/source-controller/registry/ecr"
+	"github.com/devtron-labs/source-controller/registry/gcr"
 	"net/http"
 	"os"
 	"time"
@@ -14,22 +18,39 @@ import (
 )
 
 type App struct {
-	Logger    *zap.SugaredLogger
-	Router    *api.Router
-	server    *http.Server
-	db        *pg.DB
-	scService SourceControllerService
+	Logger        *zap.SugaredLogger
+	Router        *api.Router
+	server        *http.Server
+	db            *pg.DB
+	scService     SourceControllerService
+	commonService common.CommonService
+	// leaderElectionService elects the replica reconciling the sources, sourceShardService
+	// spreads them over the replicas instead when sharding is enabled
+	leaderElectionService common.LeaderElectionService
+	sourceShardService    common.SourceShardService
+	ecrPushEventConsumer  ecr.EcrPushEventConsumer
+	gcrPushEventConsumer  gcr.GcrPushEventConsumer
 }
 
 func NewApp(Logger *zap.SugaredLogger,
 	db *pg.DB,
 	Router *api.Router,
-	scCronService SourceControllerService) *App {
+	scCronService SourceControllerService,
+	commonService common.CommonService,
+	leaderElectionService common.LeaderElectionService,
+	sourceShardService common.SourceShardService,
+	ecrPushEventConsumer ecr.EcrPushEventConsumer,
+	gcrPushEventConsumer gcr.GcrPushEventConsumer) *App {
 	return &App{
-		Logger:    Logger,
-		db:        db,
-		Router:    Router,
-		scService: scCronService,
+		Logger:                Logger,
+		db:                    db,
+		Router:                Router,
+		scService:             scCronService,
+		commonService:         commonService,
+		leaderElectionService: leaderElectionService,
+		sourceShardService:    sourceShardService,
+		ecrPushEventConsumer:  ecrPushEventConsumer,
+		gcrPushEventConsumer:  gcrPushEventConsumer,
 	}
 }
 
@@ -47,9 +68,19 @@ func (app *App) Start() {
 	httpPort := serverConfig.SERVER_HTTP_PORT
 	app.Logger.Infow("starting server on ", "httpPort", httpPort)
 	app.Router.Init()
-	_, err = NewSourceControllerCronServiceImpl(app.Logger, app.scService)
+	app.leaderElectionService.Start()
+	app.sourceShardService.Start()
+	_, err = NewSourceControllerCronServiceImpl(app.Logger, app.scService, app.commonService)
 	if err != nil {
 		app.Logger.Errorw("error in starting NewSourceControllerCronServiceImpl", "err", err)
+		os.Exit(2)
+	}
+	app.scService.WatchDeployConfigFile()
+	app.ecrPushEventConsumer.Start()
+	app.gcrPushEventConsumer.Start()
+	err = controller.StartOCIRepositoryController(app.Logger, app.scService)
+	if err != nil {
+		app.Logger.Errorw("error in starting OCIRepository controller", "err", err)
 	}
 	server := &http.Server{Addr: fmt.Sprintf(":%d", httpPort), Handler: app.Router.Router}
 	app.server = server
@@ -64,6 +95,12 @@ func (app *App) Stop() {
 	app.Logger.Infow("source controller shutdown initiating")
 	timeoutContext, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	app.Logger.Infow("stopping push event consumers")
+	app.ecrPushEventConsumer.Stop()
+	app.gcrPushEventConsumer.Stop()
+	app.Logger.Infow("giving up leadership and sources")
+	app.leaderElectionService.Stop()
+	app.sourceShardService.Stop()
 	app.Logger.Infow("closing router")
 	err := app.server.Shutdown(timeoutContext)
 	if err != nil {
@@ -5,7 +5,11 @@ package main
 
 import (
 	"github.com/devtron-labs/source-controller/api"
+	"github.com/devtron-labs/source-controller/common"
 	"github.com/devtron-labs/source-controller/internal/logger"
+	"github.com/devtron-labs/source-controller/registry"
+	"github.com/devtron-labs/source-controller/registry/ecr"
+	"github.com/devtron-labs/source-controller/registry/gcr"
 	"github.com/devtron-labs/source-controller/sql"
 	repository "github.com/devtron-labs/source-controller/sql/repo"
 	"github.com/google/wire"
@@ -16,21 +20,56 @@ func InitializeApp() (*App, error) {
 		NewApp,
 		logger.NewSugardLogger,
 		api.NewRouter,
+		api.NewWebhookDeliveryRestHandlerImpl,
+		wire.Bind(new(api.WebhookDeliveryRestHandler), new(*api.WebhookDeliveryRestHandlerImpl)),
+		api.NewSourceStatusRestHandlerImpl,
+		wire.Bind(new(api.SourceStatusRestHandler), new(*api.SourceStatusRestHandlerImpl)),
+		api.NewReconcileRestHandlerImpl,
+		wire.Bind(new(api.ReconcileRestHandler), new(*api.ReconcileRestHandlerImpl)),
+		wire.Bind(new(api.SourceReconciler), new(*SourceControllerServiceImpl)),
+		api.NewPushEventRestHandlerImpl,
+		wire.Bind(new(api.PushEventRestHandler), new(*api.PushEventRestHandlerImpl)),
+		wire.Bind(new(api.PushEventHandler), new(*SourceControllerServiceImpl)),
 		sql.GetConfig,
 		sql.NewDbConnection,
 		GetSourceControllerConfig,
 
 		repository.NewCiArtifactRepositoryImpl,
 		wire.Bind(new(repository.CiArtifactRepository), new(*repository.CiArtifactRepositoryImpl)),
+		repository.NewDiscoveredImageRepositoryImpl,
+		wire.Bind(new(repository.DiscoveredImageRepository), new(*repository.DiscoveredImageRepositoryImpl)),
+		repository.NewExternalCiSourceRepositoryImpl,
+		wire.Bind(new(repository.ExternalCiSourceRepository), new(*repository.ExternalCiSourceRepositoryImpl)),
+		repository.NewReplicaRepositoryImpl,
+		wire.Bind(new(repository.ReplicaRepository), new(*repository.ReplicaRepositoryImpl)),
+		repository.NewSourceStatusRepositoryImpl,
+		wire.Bind(new(repository.SourceStatusRepository), new(*repository.SourceStatusRepositoryImpl)),
 
 		NewSourceControllerServiceImpl,
 		wire.Bind(new(SourceControllerService), new(*SourceControllerServiceImpl)),
 
-		//ecr.NewReconciliationServiceImpl,
-		//wire.Bind(new(ecr.ReconciliationEcrService), new(*ecr.ReconciliationEcrServiceImpl)),
+		registry.NewRegistryRateLimiterImpl,
+		wire.Bind(new(registry.RegistryRateLimiter), new(*registry.RegistryRateLimiterImpl)),
 
-		//common.NewCommonServiceImpl,
-		//wire.Bind(new(common.CommonService), new(*common.CommonServiceImpl)),
+		ecr.NewReconciliationServiceImpl,
+		wire.Bind(new(ecr.ReconciliationEcrService), new(*ecr.ReconciliationEcrServiceImpl)),
+		ecr.NewEcrPushEventConsumerImpl,
+		wire.Bind(new(ecr.EcrPushEventConsumer), new(*ecr.EcrPushEventConsumerImpl)),
+		wire.Bind(new(ecr.PushEventHandler), new(*SourceControllerServiceImpl)),
+		gcr.NewGcrPushEventConsumerImpl,
+		wire.Bind(new(gcr.GcrPushEventConsumer), new(*gcr.GcrPushEventConsumerImpl)),
+		wire.Bind(new(gcr.PushEventHandler), new(*SourceControllerServiceImpl)),
+
+		common.NewCommonServiceImpl,
+		wire.Bind(new(common.CommonService), new(*common.CommonServiceImpl)),
+		common.NewExternalCiSourceServiceImpl,
+		wire.Bind(new(common.ExternalCiSourceService), new(*common.ExternalCiSourceServiceImpl)),
+		common.NewLeaderElectionServiceImpl,
+		wire.Bind(new(common.LeaderElectionService), new(*common.LeaderElectionServiceImpl)),
+		common.NewSourceShardServiceImpl,
+		wire.Bind(new(common.SourceShardService), new(*common.SourceShardServiceImpl)),
+		common.NewSourceStatusServiceImpl,
+		wire.Bind(new(common.SourceStatusService), new(*common.SourceStatusServiceImpl)),
 
 		//NewSourceControllerCronServiceImpl,
 		//wire.Bind(new(SourceControllerCronService), new(*SourceControllerCronServiceImpl)),
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/internal/util"
+	"github.com/google/go-containerregistry/pkg/name"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PushEventHandler runs the images reported pushed by the registries through the
+// notification pipeline of their sources, it is implemented by the
+// SourceControllerService of the main package.
+type PushEventHandler interface {
+	HandlePushEvents(ctx context.Context, events []bean.PushEvent) ([]*bean.PushEventReport, error)
+}
+
+type PushEventRestHandler interface {
+	HandleDistributionEvents(w http.ResponseWriter, r *http.Request)
+	HandleHarborEvents(w http.ResponseWriter, r *http.Request)
+	HandleGithubEvents(w http.ResponseWriter, r *http.Request)
+	HandleGitlabEvents(w http.ResponseWriter, r *http.Request)
+}
+
+type PushEventRestHandlerImpl struct {
+	logger           *zap.SugaredLogger
+	pushEventHandler PushEventHandler
+	config           *PushEventConfig
+}
+
+type PushEventConfig struct {
+	// DistributionToken authenticates the notifications of Docker Distribution registries, to be
+	// sent as "Authorization: Bearer <token>" through the headers of the notification endpoint.
+	// The notifications are rejected when not set.
+	DistributionToken string `env:"DISTRIBUTION_NOTIFICATION_TOKEN" envDefault:"" secretData:"-"`
+	// HarborAuthHeader is the auth header of the Harbor webhook policies, compared as is with
+	// their Authorization header. The webhooks are rejected when not set.
+	HarborAuthHeader string `env:"HARBOR_WEBHOOK_AUTH_HEADER" envDefault:"" secretData:"-"`
+	// GithubWebhookSecret is the secret of the GitHub webhooks, their X-Hub-Signature-256 being
+	// verified with it. The webhooks are rejected when not set.
+	GithubWebhookSecret string `env:"GITHUB_WEBHOOK_SECRET" envDefault:"" secretData:"-"`
+	// GitlabToken authenticates the notifications of the GitLab container registry, to be sent
+	// in the X-Gitlab-Token header. The notifications are rejected when not set.
+	GitlabToken string `env:"GITLAB_REGISTRY_NOTIFICATION_TOKEN" envDefault:"" secretData:"-"`
+}
+
+var errUnauthorizedPushEvent = &util.ApiError{
+	HttpStatusCode:  http.StatusUnauthorized,
+	Code:            "401",
+	UserMessage:     "missing or invalid credentials",
+	InternalMessage: "push event not authenticated",
+}
+
+func NewPushEventRestHandlerImpl(logger *zap.SugaredLogger,
+	pushEventHandler PushEventHandler) (*PushEventRestHandlerImpl, error) {
+	cfg := &PushEventConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing push event config", "err", err)
+		return nil, err
+	}
+	return &PushEventRestHandlerImpl{
+		logger:           logger,
+		pushEventHandler: pushEventHandler,
+		config:           cfg,
+	}, nil
+}
+
+// distributionEnvelope is the body of the notifications of Docker Distribution,
+// of media type application/vnd.docker.distribution.events.v1+json.
+type distributionEnvelope struct {
+	Events []distributionEvent `json:"events"`
+}
+
+type distributionEvent struct {
+	Id        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Target    struct {
+		MediaType  string `json:"mediaType"`
+		Digest     string `json:"digest"`
+		Repository string `json:"repository"`
+		Url        string `json:"url"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		Host string `json:"host"`
+	} `json:"request"`
+}
+
+// HandleDistributionEvents notifies the manifests pushed to a Docker Distribution registry
+// to their sources right away. The registry retries the notifications answered with an
+// error, a failed delivery to the webhook is retried by the controller instead.
+func (handler *PushEventRestHandlerImpl) HandleDistributionEvents(w http.ResponseWriter, r *http.Request) {
+	if !isValidToken(bearerToken(r), handler.config.DistributionToken) {
+		writeJsonResp(w, errUnauthorizedPushEvent, nil, http.StatusUnauthorized)
+		return
+	}
+	envelope := distributionEnvelope{}
+	err := json.NewDecoder(r.Body).Decode(&envelope)
+	if err != nil {
+		handler.logger.Errorw("error in decoding distribution notification", "err", err)
+		writeJsonResp(w, err, "invalid notification envelope", http.StatusBadRequest)
+		return
+	}
+	handler.handlePushEvents(w, r, getDistributionPushEvents(envelope, "distribution"))
+}
+
+// getDistributionPushEvents returns the pushes of tagged manifests of the envelope, the
+// pushes of layers and the manifests pushed by digest only are left out.
+func getDistributionPushEvents(envelope distributionEnvelope, receiver string) []bean.PushEvent {
+	var events []bean.PushEvent
+	for _, event := range envelope.Events {
+		if event.Action != "push" || event.Target.Tag == "" || !isManifestMediaType(event.Target.MediaType) {
+			continue
+		}
+		host := event.Request.Host
+		if targetUrl, err := url.Parse(event.Target.Url); err == nil && targetUrl.Host != "" {
+			host = targetUrl.Host
+		}
+		events = append(events, bean.PushEvent{
+			Receiver:     receiver,
+			RegistryHost: host,
+			Repository:   event.Target.Repository,
+			Tag:          event.Target.Tag,
+			Digest:       event.Target.Digest,
+			PushedOn:     event.Timestamp,
+		})
+	}
+	return events
+}
+
+func isManifestMediaType(mediaType string) bool {
+	return strings.Contains(mediaType, "manifest") || mediaType == "application/vnd.oci.image.index.v1+json"
+}
+
+// harborEvent is the body of the http webhooks of Harbor.
+type harborEvent struct {
+	Type      string `json:"type"`
+	OccurAt   int64  `json:"occur_at"`
+	EventData struct {
+		Resources []struct {
+			Digest      string `json:"digest"`
+			Tag         string `json:"tag"`
+			ResourceUrl string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			Name         string `json:"name"`
+			Namespace    string `json:"namespace"`
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+// HandleHarborEvents notifies the artifacts pushed to Harbor to their sources right away,
+// the other types of events are acknowledged and ignored.
+func (handler *PushEventRestHandlerImpl) HandleHarborEvents(w http.ResponseWriter, r *http.Request) {
+	if !isValidToken(r.Header.Get("Authorization"), handler.config.HarborAuthHeader) {
+		writeJsonResp(w, errUnauthorizedPushEvent, nil, http.StatusUnauthorized)
+		return
+	}
+	event := harborEvent{}
+	err := json.NewDecoder(r.Body).Decode(&event)
+	if err != nil {
+		handler.logger.Errorw("error in decoding harbor webhook", "err", err)
+		writeJsonResp(w, err, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+	handler.handlePushEvents(w, r, getHarborPushEvents(event))
+}
+
+// getHarborPushEvents returns the tagged artifacts of a PUSH_ARTIFACT event, located in the
+// registry of their resource url, like harbor.example.com/project/app:1.0.
+func getHarborPushEvents(event harborEvent) []bean.PushEvent {
+	if event.Type != "PUSH_ARTIFACT" {
+		return nil
+	}
+	repository := event.EventData.Repository.RepoFullName
+	if repository == "" {
+		repository = event.EventData.Repository.Namespace + "/" + event.EventData.Repository.Name
+	}
+	var pushedOn time.Time
+	if event.OccurAt != 0 {
+		pushedOn = time.Unix(event.OccurAt, 0)
+	}
+	var events []bean.PushEvent
+	for _, resource := range event.EventData.Resources {
+		if resource.Tag == "" {
+			continue
+		}
+		events = append(events, bean.PushEvent{
+			Receiver:     "harbor",
+			RegistryHost: strings.SplitN(resource.ResourceUrl, "/", 2)[0],
+			Repository:   repository,
+			Tag:          resource.Tag,
+			Digest:       resource.Digest,
+			PushedOn:     pushedOn,
+		})
+	}
+	return events
+}
+
+// githubPackageEvent is the body of the registry_package and package webhooks of GitHub,
+// the package being in registry_package or package according to the event.
+type githubPackageEvent struct {
+	Action          string         `json:"action"`
+	RegistryPackage *githubPackage `json:"registry_package"`
+	Package         *githubPackage `json:"package"`
+}
+
+type githubPackage struct {
+	Name           string `json:"name"`
+	Namespace      string `json:"namespace"`
+	PackageType    string `json:"package_type"`
+	PackageVersion struct {
+		// PackageUrl is the fully qualified name of the image, like ghcr.io/org/app:1.0
+		PackageUrl        string    `json:"package_url"`
+		CreatedAt         time.Time `json:"created_at"`
+		ContainerMetadata struct {
+			Tag struct {
+				Name   string `json:"name"`
+				Digest string `json:"digest"`
+			} `json:"tag"`
+		} `json:"container_metadata"`
+	} `json:"package_version"`
+}
+
+// HandleGithubEvents notifies the container images published to ghcr.io to their sources
+// right away, the other events of GitHub are acknowledged and ignored.
+func (handler *PushEventRestHandlerImpl) HandleGithubEvents(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handler.logger.Errorw("error in reading github webhook", "err", err)
+		writeJsonResp(w, err, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+	if !isValidSignature(body, r.Header.Get("X-Hub-Signature-256"), handler.config.GithubWebhookSecret) {
+		writeJsonResp(w, errUnauthorizedPushEvent, nil, http.StatusUnauthorized)
+		return
+	}
+	githubEvent := r.Header.Get("X-GitHub-Event")
+	if githubEvent != "registry_package" && githubEvent != "package" {
+		writeJsonResp(w, nil, []*bean.PushEventReport{}, http.StatusOK)
+		return
+	}
+	event := githubPackageEvent{}
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		handler.logger.Errorw("error in decoding github webhook", "err", err)
+		writeJsonResp(w, err, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+	handler.handlePushEvents(w, r, getGithubPushEvents(event))
+}
+
+// getGithubPushEvents returns the push of a tagged container image published to ghcr.io.
+func getGithubPushEvents(event githubPackageEvent) []bean.PushEvent {
+	githubPackage := event.RegistryPackage
+	if githubPackage == nil {
+		githubPackage = event.Package
+	}
+	if event.Action != "published" || githubPackage == nil || !strings.EqualFold(githubPackage.PackageType, "container") {
+		return nil
+	}
+	version := githubPackage.PackageVersion
+	tag, err := name.NewTag(version.PackageUrl)
+	if err != nil || version.ContainerMetadata.Tag.Name == "" {
+		return nil
+	}
+	return []bean.PushEvent{{
+		Receiver:     "github",
+		RegistryHost: tag.RegistryStr(),
+		Repository:   tag.RepositoryStr(),
+		Tag:          version.ContainerMetadata.Tag.Name,
+		Digest:       version.ContainerMetadata.Tag.Digest,
+		PushedOn:     version.CreatedAt,
+	}}
+}
+
+// HandleGitlabEvents notifies the manifests pushed to the GitLab container registry to their
+// sources right away. The registry sends the notifications of Docker Distribution, with the
+// token in the X-Gitlab-Token header set in its notification endpoint.
+func (handler *PushEventRestHandlerImpl) HandleGitlabEvents(w http.ResponseWriter, r *http.Request) {
+	if !isValidToken(r.Header.Get("X-Gitlab-Token"), handler.config.GitlabToken) {
+		writeJsonResp(w, errUnauthorizedPushEvent, nil, http.StatusUnauthorized)
+		return
+	}
+	envelope := distributionEnvelope{}
+	err := json.NewDecoder(r.Body).Decode(&envelope)
+	if err != nil {
+		handler.logger.Errorw("error in decoding gitlab registry notification", "err", err)
+		writeJsonResp(w, err, "invalid notification envelope", http.StatusBadRequest)
+		return
+	}
+	handler.handlePushEvents(w, r, getDistributionPushEvents(envelope, "gitlab"))
+}
+
+// handlePushEvents answers 503 with the reports when an event failed before its image was
+// recorded, so that the registry retries the notification, and 200 otherwise.
+func (handler *PushEventRestHandlerImpl) handlePushEvents(w http.ResponseWriter, r *http.Request, events []bean.PushEvent) {
+	if len(events) == 0 {
+		writeJsonResp(w, nil, []*bean.PushEventReport{}, http.StatusOK)
+		return
+	}
+	reports, err := handler.pushEventHandler.HandlePushEvents(r.Context(), events)
+	if err != nil {
+		writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	if hasFailedPushEvent(reports) {
+		writeJsonResp(w, nil, reports, http.StatusServiceUnavailable)
+		return
+	}
+	writeJsonResp(w, nil, reports, http.StatusOK)
+}
+
+// hasFailedPushEvent tells whether an event is neither settled nor left to the retries of
+// the controller, one of its sources having failed to handle it.
+func hasFailedPushEvent(eventReports []*bean.PushEventReport) bool {
+	for _, eventReport := range eventReports {
+		if eventReport.Settled {
+			continue
+		}
+		for _, report := range eventReport.Reports {
+			if report.Error != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func bearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "Token "} {
+		if strings.HasPrefix(authorization, scheme) {
+			return strings.TrimPrefix(authorization, scheme)
+		}
+	}
+	return authorization
+}
+
+// isValidToken compares the tokens in constant time, no token is valid when none is configured.
+func isValidToken(token, configuredToken string) bool {
+	return configuredToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(configuredToken)) == 1
+}
+
+// isValidSignature verifies the hmac sha256 signature of the body, in the "sha256=<hex>"
+// format of GitHub, no signature is valid when no secret is configured.
+func isValidSignature(body []byte, signature, secret string) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/devtron-labs/source-controller/bean"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsValidSignature(t *testing.T) {
+	body := []byte(`{"action":"published"}`)
+	// echo -n '{"action":"published"}' | openssl dgst -sha256 -hmac secret
+	signature := "sha256=73613f9b5dd2a84dbfb8808b1018f51f50de6d5733af651bb5de71db07a6d7f6"
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		secret    string
+		want      bool
+	}{
+		{"valid", body, signature, "secret", true},
+		{"tampered body", []byte(`{"action":"deleted"}`), signature, "secret", false},
+		{"wrong secret", body, signature, "other", false},
+		{"no secret configured", body, signature, "", false},
+		{"sha1 signature", body, "sha1=0123", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := isValidSignature(tt.body, tt.signature, tt.secret); got != tt.want {
+			t.Errorf("%s: isValidSignature() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+type fakePushEventHandler struct {
+	reports []*bean.PushEventReport
+	err     error
+}
+
+func (f fakePushEventHandler) HandlePushEvents(ctx context.Context, events []bean.PushEvent) ([]*bean.PushEventReport, error) {
+	return f.reports, f.err
+}
+
+func TestHandlePushEvents(t *testing.T) {
+	event := bean.PushEvent{Receiver: "gitlab", RegistryHost: "registry.gitlab.com", Repository: "group/app", Tag: "1.0.0"}
+	tests := []struct {
+		name    string
+		handler fakePushEventHandler
+		want    int
+	}{
+		{"settled", fakePushEventHandler{reports: []*bean.PushEventReport{
+			{Event: event, Reports: []*bean.ReconcileReport{{}}, Settled: true},
+		}}, http.StatusOK},
+		{"recorded for the owner", fakePushEventHandler{reports: []*bean.PushEventReport{
+			{Event: event, Reports: []*bean.ReconcileReport{{}}, Settled: false},
+		}}, http.StatusOK},
+		{"failed", fakePushEventHandler{reports: []*bean.PushEventReport{
+			{Event: event, Reports: []*bean.ReconcileReport{{}}, Settled: true},
+			{Event: event, Reports: []*bean.ReconcileReport{{Error: "registry unavailable"}}, Settled: false},
+		}}, http.StatusServiceUnavailable},
+		{"sources unavailable", fakePushEventHandler{err: errors.New("invalid deploy config")}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		handler := &PushEventRestHandlerImpl{logger: zap.NewNop().Sugar(), pushEventHandler: tt.handler, config: &PushEventConfig{}}
+		w := httptest.NewRecorder()
+		handler.handlePushEvents(w, httptest.NewRequest(http.MethodPost, "/events/gitlab", strings.NewReader("")), []bean.PushEvent{event})
+		if w.Code != tt.want {
+			t.Errorf("%s: handlePushEvents() answered %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestGetHarborPushEvents(t *testing.T) {
+	// a PUSH_ARTIFACT webhook of Harbor 2.x, with an artifact pushed by digest only
+	pushArtifact := `{
+  "type": "PUSH_ARTIFACT",
+  "occur_at": 1709287200,
+  "operator": "admin",
+  "event_data": {
+    "resources": [
+      {
+        "digest": "sha256:6ec128e26cd5a8a85d5cbd19d5ef9f72d5126c8b0f2b9f6a5ac4e5da9c3a8b71",
+        "tag": "1.1.0",
+        "resource_url": "harbor.example.com/devtron/app:1.1.0"
+      },
+      {
+        "digest": "sha256:0f4e7a3c9b1d2e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f",
+        "tag": "",
+        "resource_url": "harbor.example.com/devtron/app@sha256:0f4e7a3c9b1d2e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f"
+      }
+    ],
+    "repository": {
+      "date_created": 1709200000,
+      "name": "app",
+      "namespace": "devtron",
+      "repo_full_name": "devtron/app",
+      "repo_type": "private"
+    }
+  }
+}`
+	tests := []struct {
+		name    string
+		payload string
+		want    []bean.PushEvent
+	}{
+		{
+			name:    "push artifact",
+			payload: pushArtifact,
+			want: []bean.PushEvent{{
+				Receiver:     "harbor",
+				RegistryHost: "harbor.example.com",
+				Repository:   "devtron/app",
+				Tag:          "1.1.0",
+				Digest:       "sha256:6ec128e26cd5a8a85d5cbd19d5ef9f72d5126c8b0f2b9f6a5ac4e5da9c3a8b71",
+				PushedOn:     time.Unix(1709287200, 0),
+			}},
+		},
+		{
+			name:    "repository without full name",
+			payload: strings.Replace(pushArtifact, `"repo_full_name": "devtron/app",`, "", 1),
+			want: []bean.PushEvent{{
+				Receiver:     "harbor",
+				RegistryHost: "harbor.example.com",
+				Repository:   "devtron/app",
+				Tag:          "1.1.0",
+				Digest:       "sha256:6ec128e26cd5a8a85d5cbd19d5ef9f72d5126c8b0f2b9f6a5ac4e5da9c3a8b71",
+				PushedOn:     time.Unix(1709287200, 0),
+			}},
+		},
+		{name: "pull artifact", payload: strings.Replace(pushArtifact, "PUSH_ARTIFACT", "PULL_ARTIFACT", 1)},
+		{name: "delete artifact", payload: strings.Replace(pushArtifact, "PUSH_ARTIFACT", "DELETE_ARTIFACT", 1)},
+		{name: "scanning completed", payload: strings.Replace(pushArtifact, "PUSH_ARTIFACT", "SCANNING_COMPLETED", 1)},
+	}
+	for _, tt := range tests {
+		event := harborEvent{}
+		if err := json.Unmarshal([]byte(tt.payload), &event); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := getHarborPushEvents(event); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: getHarborPushEvents() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetDistributionPushEvents(t *testing.T) {
+	payload := `{"events": [
+  {"action": "push", "timestamp": "2024-03-01T10:00:00Z",
+   "target": {"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "digest": "sha256:a", "repository": "devtron/app",
+     "url": "https://registry.example.com/v2/devtron/app/manifests/sha256:a", "tag": "1.1.0"},
+   "request": {"host": "registry.internal:5000"}},
+  {"action": "push", "target": {"mediaType": "application/octet-stream", "digest": "sha256:layer", "repository": "devtron/app"}},
+  {"action": "push", "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:b", "repository": "devtron/app"}},
+  {"action": "pull", "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:a", "repository": "devtron/app", "tag": "1.1.0"}}
+]}`
+	envelope := distributionEnvelope{}
+	if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	want := []bean.PushEvent{{
+		Receiver:     "gitlab",
+		RegistryHost: "registry.example.com",
+		Repository:   "devtron/app",
+		Tag:          "1.1.0",
+		Digest:       "sha256:a",
+		PushedOn:     time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	}}
+	if got := getDistributionPushEvents(envelope, "gitlab"); !reflect.DeepEqual(got, want) {
+		t.Errorf("getDistributionPushEvents() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetGithubPushEvents(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	published := githubPackageEvent{Action: "published", RegistryPackage: &githubPackage{Name: "app", Namespace: "devtron-labs", PackageType: "CONTAINER"}}
+	published.RegistryPackage.PackageVersion.PackageUrl = "ghcr.io/devtron-labs/app:1.1.0"
+	published.RegistryPackage.PackageVersion.CreatedAt = createdAt
+	published.RegistryPackage.PackageVersion.ContainerMetadata.Tag.Name = "1.1.0"
+	published.RegistryPackage.PackageVersion.ContainerMetadata.Tag.Digest = "sha256:a"
+	want := []bean.PushEvent{{Receiver: "github", RegistryHost: "ghcr.io", Repository: "devtron-labs/app", Tag: "1.1.0", Digest: "sha256:a", PushedOn: createdAt}}
+	if got := getGithubPushEvents(published); !reflect.DeepEqual(got, want) {
+		t.Errorf("getGithubPushEvents() = %+v, want %+v", got, want)
+	}
+	updated := published
+	updated.Action = "updated"
+	npm := githubPackageEvent{Action: "published", Package: &githubPackage{PackageType: "npm"}}
+	for _, event := range []githubPackageEvent{updated, npm} {
+		if got := getGithubPushEvents(event); got != nil {
+			t.Errorf("getGithubPushEvents(%+v) = %+v, want none", event, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/config"
+	"github.com/devtron-labs/source-controller/internal/util"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SourceReconciler reconciles sources on demand, it is implemented by the
+// SourceControllerService of the main package.
+type SourceReconciler interface {
+	TriggerReconcile(ctx context.Context, request bean.ReconcileRequest) ([]*bean.ReconcileReport, error)
+	DryRunReconcile(ctx context.Context, request bean.ReconcileRequest, deployConfigs []bean.DeployConfig) ([]*bean.ReconcileReport, error)
+}
+
+type ReconcileRestHandler interface {
+	TriggerReconcile(w http.ResponseWriter, r *http.Request)
+	DryRunReconcile(w http.ResponseWriter, r *http.Request)
+}
+
+var errNoSourceSelected = &util.ApiError{
+	HttpStatusCode:  http.StatusBadRequest,
+	Code:            "400",
+	UserMessage:     "one of all, externalCiId or repoName is required",
+	InternalMessage: "no source selected",
+}
+
+type ReconcileRestHandlerImpl struct {
+	logger           *zap.SugaredLogger
+	sourceReconciler SourceReconciler
+}
+
+func NewReconcileRestHandlerImpl(logger *zap.SugaredLogger,
+	sourceReconciler SourceReconciler) *ReconcileRestHandlerImpl {
+	return &ReconcileRestHandlerImpl{
+		logger:           logger,
+		sourceReconciler: sourceReconciler,
+	}
+}
+
+// TriggerReconcile reconciles the requested sources right away and returns, for each
+// of them, the tags discovered, the digests notified and the error if any.
+func (handler *ReconcileRestHandlerImpl) TriggerReconcile(w http.ResponseWriter, r *http.Request) {
+	request := bean.ReconcileRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		handler.logger.Errorw("error in decoding reconcile request", "err", err)
+		writeJsonResp(w, err, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !request.All && request.ExternalCiId == 0 && request.RepoName == "" {
+		writeJsonResp(w, errNoSourceSelected, nil, http.StatusBadRequest)
+		return
+	}
+	handler.logger.Infow("reconcile triggered", "request", request)
+	reports, err := handler.sourceReconciler.TriggerReconcile(r.Context(), request)
+	if err != nil {
+		writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	writeJsonResp(w, nil, reports, http.StatusOK)
+}
+
+// DryRunReconcile previews the reconciliation of sources without calling the webhook.
+// A yaml body is a list of sources in the DEPLOY_CONFIG_EXTERNAL_CI format, which
+// need not be configured yet and are validated like the config file, without access to
+// the env and the files of the controller. A json body selects configured sources like
+// TriggerReconcile.
+func (handler *ReconcileRestHandlerImpl) DryRunReconcile(w http.ResponseWriter, r *http.Request) {
+	request := bean.ReconcileRequest{}
+	var deployConfigs []bean.DeployConfig
+	if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			deployConfigs, err = config.ParseRequestedDeployConfigs(body)
+		}
+		if err != nil {
+			handler.logger.Errorw("error in decoding dry run sources", "err", err)
+			writeJsonResp(w, err, "invalid deploy config", http.StatusBadRequest)
+			return
+		}
+		if len(deployConfigs) == 0 {
+			writeJsonResp(w, errors.New("no source given"), nil, http.StatusBadRequest)
+			return
+		}
+	} else {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			handler.logger.Errorw("error in decoding reconcile request", "err", err)
+			writeJsonResp(w, err, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !request.All && request.ExternalCiId == 0 && request.RepoName == "" {
+			writeJsonResp(w, errNoSourceSelected, nil, http.StatusBadRequest)
+			return
+		}
+	}
+	reports, err := handler.sourceReconciler.DryRunReconcile(r.Context(), request, deployConfigs)
+	if err != nil {
+		writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	writeJsonResp(w, nil, reports, http.StatusOK)
+}
@@ -2,18 +2,69 @@ package api
 
 import (
 	"encoding/json"
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/internal/util"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"net/http"
 )
 
 type Router struct {
-	logger *zap.SugaredLogger
-	Router *mux.Router
+	logger                     *zap.SugaredLogger
+	Router                     *mux.Router
+	webhookDeliveryRestHandler WebhookDeliveryRestHandler
+	sourceStatusRestHandler    SourceStatusRestHandler
+	reconcileRestHandler       ReconcileRestHandler
+	pushEventRestHandler       PushEventRestHandler
+	config                     *RouterConfig
 }
 
-func NewRouter(logger *zap.SugaredLogger) *Router {
-	return &Router{logger: logger, Router: mux.NewRouter()}
+type RouterConfig struct {
+	// AdminToken authenticates the requests reconciling sources or replaying webhook deliveries,
+	// to be sent as "Authorization: Bearer <token>". These requests are rejected when not set.
+	AdminToken string `env:"ADMIN_API_TOKEN" envDefault:"" secretData:"-"`
+}
+
+var errUnauthorizedRequest = &util.ApiError{
+	HttpStatusCode:  http.StatusUnauthorized,
+	Code:            "401",
+	UserMessage:     "missing or invalid credentials",
+	InternalMessage: "admin request not authenticated",
+}
+
+func NewRouter(logger *zap.SugaredLogger,
+	webhookDeliveryRestHandler WebhookDeliveryRestHandler,
+	sourceStatusRestHandler SourceStatusRestHandler,
+	reconcileRestHandler ReconcileRestHandler,
+	pushEventRestHandler PushEventRestHandler) (*Router, error) {
+	cfg := &RouterConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing router config", "err", err)
+		return nil, err
+	}
+	return &Router{
+		logger:                     logger,
+		Router:                     mux.NewRouter(),
+		webhookDeliveryRestHandler: webhookDeliveryRestHandler,
+		sourceStatusRestHandler:    sourceStatusRestHandler,
+		reconcileRestHandler:       reconcileRestHandler,
+		pushEventRestHandler:       pushEventRestHandler,
+		config:                     cfg,
+	}, nil
+}
+
+// requireAdminToken lets through the requests bearing the admin token only, the sources they
+// reconcile reach registries and webhooks with the credentials and the network of the controller.
+func (r Router) requireAdminToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		if !isValidToken(bearerToken(request), r.config.AdminToken) {
+			writeJsonResp(w, errUnauthorizedRequest, nil, http.StatusUnauthorized)
+			return
+		}
+		handler(w, request)
+	}
 }
 
 func (r Router) Init() {
@@ -32,4 +83,16 @@ func (r Router) Init() {
 		_, _ = writer.Write(b)
 	})
 
+	r.Router.Path("/metrics").Handler(promhttp.Handler()).Methods(http.MethodGet)
+	r.Router.Path("/source/status").HandlerFunc(r.sourceStatusRestHandler.GetSourceStatuses).Methods(http.MethodGet)
+	r.Router.Path("/source/status/{externalCiId}").HandlerFunc(r.sourceStatusRestHandler.GetSourceStatusesByExternalCiId).Methods(http.MethodGet)
+	r.Router.Path("/source/reconcile").HandlerFunc(r.requireAdminToken(r.reconcileRestHandler.TriggerReconcile)).Methods(http.MethodPost)
+	r.Router.Path("/source/dry-run").HandlerFunc(r.requireAdminToken(r.reconcileRestHandler.DryRunReconcile)).Methods(http.MethodPost)
+	r.Router.Path("/events/distribution").HandlerFunc(r.pushEventRestHandler.HandleDistributionEvents).Methods(http.MethodPost)
+	r.Router.Path("/events/harbor").HandlerFunc(r.pushEventRestHandler.HandleHarborEvents).Methods(http.MethodPost)
+	r.Router.Path("/events/github").HandlerFunc(r.pushEventRestHandler.HandleGithubEvents).Methods(http.MethodPost)
+	r.Router.Path("/events/gitlab").HandlerFunc(r.pushEventRestHandler.HandleGitlabEvents).Methods(http.MethodPost)
+	r.Router.Path("/webhook/dead-letter").HandlerFunc(r.webhookDeliveryRestHandler.GetDeadLetterImages).Methods(http.MethodGet)
+	r.Router.Path("/webhook/dead-letter/{id}/replay").HandlerFunc(r.requireAdminToken(r.webhookDeliveryRestHandler.ReplayDeadLetterImage)).Methods(http.MethodPost)
+
 }
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+	"github.com/devtron-labs/source-controller/common"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type SourceStatusRestHandler interface {
+	GetSourceStatuses(w http.ResponseWriter, r *http.Request)
+	GetSourceStatusesByExternalCiId(w http.ResponseWriter, r *http.Request)
+}
+
+type SourceStatusRestHandlerImpl struct {
+	logger              *zap.SugaredLogger
+	sourceStatusService common.SourceStatusService
+}
+
+func NewSourceStatusRestHandlerImpl(logger *zap.SugaredLogger,
+	sourceStatusService common.SourceStatusService) *SourceStatusRestHandlerImpl {
+	return &SourceStatusRestHandlerImpl{
+		logger:              logger,
+		sourceStatusService: sourceStatusService,
+	}
+}
+
+// GetSourceStatuses lists the configured sources along with the outcome of their last reconciliation.
+func (handler *SourceStatusRestHandlerImpl) GetSourceStatuses(w http.ResponseWriter, r *http.Request) {
+	sourceStatuses, err := handler.sourceStatusService.GetSourceStatuses()
+	if err != nil {
+		writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	writeJsonResp(w, nil, sourceStatuses, http.StatusOK)
+}
+
+// GetSourceStatusesByExternalCiId lists the configured sources of an external ci pipeline.
+func (handler *SourceStatusRestHandlerImpl) GetSourceStatusesByExternalCiId(w http.ResponseWriter, r *http.Request) {
+	externalCiId, err := strconv.Atoi(mux.Vars(r)["externalCiId"])
+	if err != nil {
+		writeJsonResp(w, err, "invalid externalCiId", http.StatusBadRequest)
+		return
+	}
+	sourceStatuses, err := handler.sourceStatusService.GetSourceStatusesByExternalCiId(externalCiId)
+	if err != nil {
+		writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	if len(sourceStatuses) == 0 {
+		writeJsonResp(w, fmt.Errorf("no source configured for external ci %d", externalCiId), nil, http.StatusNotFound)
+		return
+	}
+	writeJsonResp(w, nil, sourceStatuses, http.StatusOK)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"github.com/devtron-labs/source-controller/common"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type WebhookDeliveryRestHandler interface {
+	GetDeadLetterImages(w http.ResponseWriter, r *http.Request)
+	ReplayDeadLetterImage(w http.ResponseWriter, r *http.Request)
+}
+
+type WebhookDeliveryRestHandlerImpl struct {
+	logger        *zap.SugaredLogger
+	commonService common.CommonService
+}
+
+func NewWebhookDeliveryRestHandlerImpl(logger *zap.SugaredLogger,
+	commonService common.CommonService) *WebhookDeliveryRestHandlerImpl {
+	return &WebhookDeliveryRestHandlerImpl{
+		logger:        logger,
+		commonService: commonService,
+	}
+}
+
+// GetDeadLetterImages lists the images whose webhook delivery was given up.
+func (handler *WebhookDeliveryRestHandlerImpl) GetDeadLetterImages(w http.ResponseWriter, r *http.Request) {
+	deadLetterImages, err := handler.commonService.GetDeadLetterImages()
+	if err != nil {
+		writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	writeJsonResp(w, nil, deadLetterImages, http.StatusOK)
+}
+
+// ReplayDeadLetterImage delivers a dead letter image again and returns its outcome.
+func (handler *WebhookDeliveryRestHandlerImpl) ReplayDeadLetterImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJsonResp(w, err, "invalid id", http.StatusBadRequest)
+		return
+	}
+	discoveredImage, err := handler.commonService.ReplayDeadLetterImage(id)
+	if err != nil {
+		handler.logger.Errorw("error in replaying dead letter image", "err", err, "id", id)
+		writeJsonResp(w, err, nil, http.StatusInternalServerError)
+		return
+	}
+	writeJsonResp(w, nil, discoveredImage, http.StatusOK)
+}
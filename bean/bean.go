@@ -1,9 +1,11 @@
 package bean
 
 import (
+	"context"
 	"fmt"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"strconv"
+	"time"
 )
 
 // Result is a type for creating an abstraction for the controller-runtime
@@ -17,6 +19,27 @@ const (
 	MaterialTypeGit string = "git"
 )
 
+// Values of TagPolicy.TagOrder, the order in which the tags of a source are
+// considered before the newest ImageShowCount of them are picked.
+const (
+	// TagOrderRegistry keeps the order returned by the registry, which is lexical for most of them.
+	TagOrderRegistry = ""
+	// TagOrderAlphabetical orders the tags in descending lexical order.
+	TagOrderAlphabetical = "alphabetical"
+	// TagOrderNumeric orders numeric tags, highest first.
+	TagOrderNumeric = "numeric"
+	// TagOrderSemver orders semver tags, highest first.
+	TagOrderSemver = "semver"
+	// TagOrderCreated orders the tags by the created time of the image config.
+	TagOrderCreated = "created"
+	// TagOrderAnnotation orders the tags by the org.opencontainers.image.created
+	// annotation of the manifest, or the label of the same name of the image config.
+	TagOrderAnnotation = "annotation"
+	// TagOrderPushTime orders the tags by the push time reported by the registry,
+	// supported for ECR, GCR, Artifact Registry and Docker Hub.
+	TagOrderPushTime = "push-time"
+)
+
 const (
 	// ResultEmpty indicates a reconcile result which does not requeue. It is
 	// also used when returning an error, since the error overshadows result.
@@ -125,6 +148,7 @@ type OCIRepositoryRef struct {
 }
 
 // Artifact represents the output of a Source reconciliation.
+// +kubebuilder:object:generate=true
 type Artifact struct {
 	// Path is the relative file path of the Artifact. It can be used to locate
 	// the file in the root of the Artifact storage on the local file system of
@@ -191,6 +215,201 @@ type OCIRepositoryStatus struct {
 	ObservedIgnore *string `json:"observedIgnore,omitempty"`
 }
 
+// DeployConfig is a single source watched by the controller, each discovered
+// image of the repository is sent to the external ci pipeline ExternalCiId.
+type DeployConfig struct {
+	ExternalCiId int    `yaml:"EXTERNAL_CI_ID"`
+	RepoName     string `yaml:"REPO_NAME_EXTERNAL_CI"`
+	RegistryURL  string `yaml:"REGISTRY_URL_EXTERNAL_CI"`
+	// RegistryType is one of the registry.REGISTRYTYPE_* values, sources of type
+	// ecr are reconciled through the ECR api instead of the registry api.
+	RegistryType       string `yaml:"REGISTRY_TYPE"`
+	TagPolicy          `yaml:",inline"`
+	RegistryCredential `yaml:",inline"`
+	EcrConfig          `yaml:",inline"`
+	Schedule           `yaml:",inline"`
+}
+
+// Schedule sets when a source is reconciled, the durations are in the format of
+// time.ParseDuration, like "90s" or "10m". The defaults of the controller apply
+// to the durations left empty.
+type Schedule struct {
+	// Interval is the time between the end of a reconciliation and the start of the next one
+	Interval string `yaml:"INTERVAL"`
+	// Timeout bounds the registry operations of a reconciliation
+	Timeout string `yaml:"TIMEOUT"`
+	// Jitter is the maximum random delay added to each interval, spreading the load on the registry
+	Jitter string `yaml:"JITTER"`
+	// Suspend stops the scheduled reconciliations of the source
+	Suspend bool `yaml:"SUSPEND"`
+}
+
+// GetInterval returns the interval of the source, or defaultInterval when not set.
+func (s Schedule) GetInterval(defaultInterval time.Duration) time.Duration {
+	return parseDurationOrDefault(s.Interval, defaultInterval)
+}
+
+// GetTimeout returns the timeout of the source, or defaultTimeout when not set.
+func (s Schedule) GetTimeout(defaultTimeout time.Duration) time.Duration {
+	return parseDurationOrDefault(s.Timeout, defaultTimeout)
+}
+
+// GetJitter returns the maximum jitter of the source, or defaultJitter when not set.
+func (s Schedule) GetJitter(defaultJitter time.Duration) time.Duration {
+	return parseDurationOrDefault(s.Jitter, defaultJitter)
+}
+
+func parseDurationOrDefault(value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return duration
+}
+
+// TagPolicy selects and orders the tags of a source, the newest ImageShowCount
+// of the selected tags are picked after ordering.
+type TagPolicy struct {
+	// TagOrder is one of the TagOrder* values.
+	TagOrder string `yaml:"TAG_ORDER"`
+	// SemverRange is a semver constraint like ">=1.4.0 <2.0.0", when set only the
+	// matching tags are considered and they are ordered by version.
+	SemverRange string `yaml:"SEMVER_RANGE"`
+	// SemverIncludePrerelease makes a prerelease match SemverRange when its
+	// release version matches, prereleases are excluded otherwise.
+	SemverIncludePrerelease bool `yaml:"SEMVER_INCLUDE_PRERELEASE"`
+	// TagIncludeRegex keeps only the tags matching it.
+	TagIncludeRegex string `yaml:"TAG_INCLUDE_REGEX"`
+	// TagExcludeRegex drops the tags matching it.
+	TagExcludeRegex string `yaml:"TAG_EXCLUDE_REGEX"`
+	// TagExtract is a template like "$buildnum" expanded with the submatches of
+	// TagIncludeRegex, the expanded value is ordered instead of the tag itself.
+	TagExtract string `yaml:"TAG_EXTRACT"`
+}
+
+// GetTagOrder returns the effective order of the tags, a semver range always
+// orders by version.
+func (p TagPolicy) GetTagOrder() string {
+	if p.SemverRange != "" {
+		return TagOrderSemver
+	}
+	return p.TagOrder
+}
+
+// ReconcileReport is what the reconciliation of a source found and did, it is
+// returned partially filled along with the error when the reconciliation fails.
+type ReconcileReport struct {
+	SourceKey    string `json:"sourceKey"`
+	ExternalCiId int    `json:"externalCiId"`
+	// TagCount is the number of tags of the repository left after filtering
+	TagCount int `json:"tagCount"`
+	// Tags are the newest tags selected for notification, in order
+	Tags []string `json:"tags"`
+	// DiscoveredDigests maps the digests resolved for the selected tags to their tag
+	DiscoveredDigests map[string]string `json:"discoveredDigests"`
+	// PushedOn maps the discovered digests to their push or creation time, when known
+	PushedOn map[string]time.Time `json:"pushedOn,omitempty"`
+	// NotifiedDigests are the digests delivered to the external ci webhook
+	NotifiedDigests []string `json:"notifiedDigests"`
+	// Error is the error the reconciliation failed with, set for on demand reconciliations
+	Error string `json:"error,omitempty"`
+	// DryRun is set when the webhook was not called, Plan then lists what would have been done
+	DryRun bool                   `json:"dryRun,omitempty"`
+	Plan   []*PlannedNotification `json:"plan,omitempty"`
+}
+
+// PlannedNotification is what a reconciliation would do with a discovered digest.
+type PlannedNotification struct {
+	Digest string `json:"digest"`
+	Tag    string `json:"tag"`
+	Image  string `json:"image"`
+	// AlreadyPresent is set when the digest is already a ci artifact of the pipeline
+	AlreadyPresent bool `json:"alreadyPresent"`
+	// NotificationStatus is the state of the digest in the discovery ledger, if already observed
+	NotificationStatus string `json:"notificationStatus,omitempty"`
+	// WebhookURL and Payload are the request that would be posted, unset when the digest would not be notified
+	WebhookURL string      `json:"webhookUrl,omitempty"`
+	Payload    *ExternalCI `json:"payload,omitempty"`
+}
+
+type dryRunContextKey struct{}
+
+// ContextWithDryRun returns a context making the reconciliations run with it plan
+// their notifications instead of calling the webhook.
+func ContextWithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunContextKey{}, true)
+}
+
+// IsDryRun tells whether the context was returned by ContextWithDryRun.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunContextKey{}).(bool)
+	return dryRun
+}
+
+// ReconcileRequest selects the sources to reconcile on demand, either all of
+// them or the ones matching the external ci id and/or the repo name given.
+type ReconcileRequest struct {
+	All          bool   `json:"all"`
+	ExternalCiId int    `json:"externalCiId"`
+	RepoName     string `json:"repoName"`
+}
+
+// Matches tells whether the source is selected by the request.
+func (r ReconcileRequest) Matches(deployConfig DeployConfig) bool {
+	if r.All {
+		return true
+	}
+	if r.ExternalCiId == 0 && r.RepoName == "" {
+		return false
+	}
+	return (r.ExternalCiId == 0 || r.ExternalCiId == deployConfig.ExternalCiId) &&
+		(r.RepoName == "" || r.RepoName == deployConfig.RepoName)
+}
+
+// NewReconcileReport returns an empty report for the source.
+func NewReconcileReport(deployConfig DeployConfig) *ReconcileReport {
+	return &ReconcileReport{
+		SourceKey:         deployConfig.GetSourceKey(),
+		ExternalCiId:      deployConfig.ExternalCiId,
+		Tags:              []string{},
+		DiscoveredDigests: map[string]string{},
+		PushedOn:          map[string]time.Time{},
+		NotifiedDigests:   []string{},
+	}
+}
+
+// GetSourceKey returns the key identifying the source in the tables of the controller.
+func (c DeployConfig) GetSourceKey() string {
+	return fmt.Sprintf("%d/%s/%s", c.ExternalCiId, c.RegistryURL, c.RepoName)
+}
+
+// EcrConfig holds the settings of an ECR source. When the access keys are not
+// set, the default aws credential chain (env, shared config, IRSA) is used.
+type EcrConfig struct {
+	AwsRegion          string `yaml:"AWS_REGION"`
+	AwsRegistryId      string `yaml:"AWS_REGISTRY_ID"`
+	AwsAccessKeyId     string `yaml:"AWS_ACCESS_KEY_ID"`
+	AwsSecretAccessKey string `yaml:"AWS_SECRET_ACCESS_KEY" secretData:"-"`
+}
+
+// RegistryCredential holds the credentials used to authenticate against the
+// container registry of a source. Only one of them is used, in the order
+// token, username/password, docker config.json; when none is set the registry
+// is accessed anonymously.
+type RegistryCredential struct {
+	Username string `yaml:"REGISTRY_USERNAME"`
+	Password string `yaml:"REGISTRY_PASSWORD" secretData:"-"`
+	// Token is a bearer token sent as is to the registry.
+	Token string `yaml:"REGISTRY_TOKEN" secretData:"-"`
+	// DockerConfigJsonPath is the path of a mounted docker config.json file.
+	DockerConfigJsonPath string `yaml:"DOCKER_CONFIG_JSON_PATH"`
+	// DockerConfigJson is the base64 encoded content of a docker config.json.
+	DockerConfigJson string `yaml:"DOCKER_CONFIG_JSON" secretData:"-"`
+}
+
 type ExternalCI struct {
 	DockerImage  string `json:"dockerImage" validate:"required,image-validator"`
 	Digest       string `json:"digest"`
@@ -0,0 +1,64 @@
+package bean
+
+import (
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ecrHostRegex = regexp.MustCompile(`^(\d{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com$`)
+
+// PushEvent is the push of an image reported by a registry, through a webhook or a
+// queue, instead of being discovered by polling the source.
+type PushEvent struct {
+	// Receiver is the webhook or the queue the event was received from, like "distribution"
+	Receiver string `json:"receiver"`
+	// RegistryHost and Repository locate the pushed image, like "registry.example.com:5000" and "team/app"
+	RegistryHost string `json:"registryHost"`
+	Repository   string `json:"repository"`
+	Tag          string `json:"tag"`
+	// Digest is the digest of the manifest, resolved from the registry when the event lacks it
+	Digest   string    `json:"digest,omitempty"`
+	PushedOn time.Time `json:"pushedOn,omitempty"`
+}
+
+// PushEventReport is what was done with a push event for every source of its repository.
+type PushEventReport struct {
+	Event   PushEvent          `json:"event"`
+	Reports []*ReconcileReport `json:"reports"`
+	// Settled is set when the image needs no further delivery for any of the sources: it was
+	// notified, already present, dead lettered or left out by the tag policy. The delivery of
+	// an unsettled image is retried by the replica owning the source.
+	Settled bool `json:"settled"`
+}
+
+// MatchesRepository tells whether the source polls the given repository, the names being
+// compared once normalized, like docker.io/nginx and index.docker.io/library/nginx.
+func (c DeployConfig) MatchesRepository(registryHost, repository string) bool {
+	if c.RegistryURL == "" {
+		return c.matchesEcrRepository(registryHost, repository)
+	}
+	configured, err := name.NewRepository(fmt.Sprintf("%s/%s", c.RegistryURL, c.RepoName))
+	if err != nil {
+		return false
+	}
+	registryHost = strings.TrimPrefix(strings.TrimPrefix(registryHost, "https://"), "http://")
+	pushed, err := name.NewRepository(fmt.Sprintf("%s/%s", strings.TrimSuffix(registryHost, "/"), repository))
+	if err != nil {
+		return false
+	}
+	return configured.Name() == pushed.Name()
+}
+
+// matchesEcrRepository matches the ecr sources without registry url, whose host is derived
+// from their registry id and region. Both must be known, resolved from the aws config when
+// the source leaves them empty, a source without them matching no repository.
+func (c DeployConfig) matchesEcrRepository(registryHost, repository string) bool {
+	submatches := ecrHostRegex.FindStringSubmatch(registryHost)
+	if submatches == nil || c.RepoName != repository || c.AwsRegistryId == "" || c.AwsRegion == "" {
+		return false
+	}
+	return c.AwsRegistryId == submatches[1] && c.AwsRegion == submatches[2]
+}
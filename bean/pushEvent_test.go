@@ -0,0 +1,45 @@
+package bean
+
+import "testing"
+
+func TestMatchesRepository(t *testing.T) {
+	tests := []struct {
+		registryURL  string
+		repoName     string
+		registryHost string
+		repository   string
+		want         bool
+	}{
+		{"registry.example.com:5000", "team/app", "registry.example.com:5000", "team/app", true},
+		{"registry.example.com:5000", "team/app", "https://registry.example.com:5000/", "team/app", true},
+		{"registry.example.com:5000", "team/app", "registry.example.com", "team/app", false},
+		{"registry.example.com:5000", "team/app", "registry.example.com:5000", "team/other", false},
+		{"docker.io", "nginx", "index.docker.io", "library/nginx", true},
+		{"harbor.example.com/project", "app", "harbor.example.com", "project/app", true},
+		{"", "app", "registry.example.com", "app", false},
+	}
+	for _, tt := range tests {
+		deployConfig := DeployConfig{RegistryURL: tt.registryURL, RepoName: tt.repoName}
+		if got := deployConfig.MatchesRepository(tt.registryHost, tt.repository); got != tt.want {
+			t.Errorf("MatchesRepository(%q, %q) of %s/%s = %v, want %v", tt.registryHost, tt.repository, tt.registryURL, tt.repoName, got, tt.want)
+		}
+	}
+	ecrHost := "123456789012.dkr.ecr.eu-west-1.amazonaws.com"
+	ecrTests := []struct {
+		ecrConfig EcrConfig
+		want      bool
+	}{
+		{EcrConfig{}, false},
+		{EcrConfig{AwsRegistryId: "123456789012"}, false},
+		{EcrConfig{AwsRegion: "eu-west-1"}, false},
+		{EcrConfig{AwsRegistryId: "123456789012", AwsRegion: "eu-west-1"}, true},
+		{EcrConfig{AwsRegistryId: "210987654321", AwsRegion: "eu-west-1"}, false},
+		{EcrConfig{AwsRegistryId: "123456789012", AwsRegion: "us-east-1"}, false},
+	}
+	for _, tt := range ecrTests {
+		deployConfig := DeployConfig{RepoName: "app", EcrConfig: tt.ecrConfig}
+		if got := deployConfig.MatchesRepository(ecrHost, "app"); got != tt.want {
+			t.Errorf("MatchesRepository(%q, app) of ecr source %+v = %v, want %v", ecrHost, tt.ecrConfig, got, tt.want)
+		}
+	}
+}
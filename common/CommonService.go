@@ -2,43 +2,75 @@ package common
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/caarlos0/env"
 	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/internal/metrics"
+	"github.com/devtron-labs/source-controller/internal/util"
 	repository "github.com/devtron-labs/source-controller/sql/repo"
 	"go.uber.org/zap"
+	"io"
 	"k8s.io/apimachinery/pkg/util/json"
 	"net/http"
+	"time"
 )
 
 type CommonService interface {
 	FilterAlreadyPresentArtifacts(imageDigests []string, digestTagMap map[string]string, externalCiId int) error
 	CallExternalCIWebHook(digest, tag, host, repoName string, externalCiId int) error
+	NotifyDiscoveredImages(deployConfig bean.DeployConfig, host string, digestTagMap map[string]string, pushedOn map[string]time.Time) ([]*repository.DiscoveredImage, error)
+	RecordDiscoveredImages(deployConfig bean.DeployConfig, host string, digestTagMap map[string]string, pushedOn map[string]time.Time) error
+	GetNotificationStatus(deployConfig bean.DeployConfig, digest string) (string, error)
+	GetUnknownDigests(deployConfig bean.DeployConfig, digests []string) ([]string, error)
+	PlanDiscoveredImages(deployConfig bean.DeployConfig, host string, digestTagMap map[string]string) ([]*bean.PlannedNotification, error)
+	ReportDiscoveredImages(ctx context.Context, deployConfig bean.DeployConfig, host string, report *bean.ReconcileReport) error
+	DeliverPendingImages()
+	GetDeadLetterImages() ([]*repository.DiscoveredImage, error)
+	ReplayDeadLetterImage(id int) (*repository.DiscoveredImage, error)
 }
 
 type CommonServiceImpl struct {
-	logger               *zap.SugaredLogger
-	ciArtifactRepository repository.CiArtifactRepository
-	config               *CommonServiceConfig
+	logger                    *zap.SugaredLogger
+	ciArtifactRepository      repository.CiArtifactRepository
+	discoveredImageRepository repository.DiscoveredImageRepository
+	config                    *CommonServiceConfig
+	sourceShardService        SourceShardService
+	httpClient                *http.Client
 }
 
 type CommonServiceConfig struct {
 	ApiToken    string `env:"API_TOKEN_EXTERNAL_CI" envDefault:""`
 	ServiceName string `env:"WEBHOOK_SERVICE_NAME" envDefault:"devtron-service"`
 	Namespace   string `env:"WEBHOOK_NAMESPACE" envDefault:"devtroncd"`
+	// WebhookTimeoutSeconds bounds every call to the external ci webhook
+	WebhookTimeoutSeconds     int `env:"WEBHOOK_TIMEOUT_SECONDS" envDefault:"10"`
+	WebhookMaxAttempts        int `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"5"`
+	WebhookBackoffBaseSeconds int `env:"WEBHOOK_BACKOFF_BASE_SECONDS" envDefault:"2"`
+	WebhookBackoffMaxSeconds  int `env:"WEBHOOK_BACKOFF_MAX_SECONDS" envDefault:"300"`
+	// DryRun disables every call to the webhook, the discovered images are only planned
+	DryRun bool `env:"DRY_RUN" envDefault:"false"`
+	// PushToTriggerMaxSeconds leaves out of the push to trigger histogram the images pushed long
+	// before being notified, like the existing images of a newly added source
+	PushToTriggerMaxSeconds int `env:"PUSH_TO_TRIGGER_MAX_SECONDS" envDefault:"86400"`
 }
 
 func NewCommonServiceImpl(logger *zap.SugaredLogger,
-	ciArtifactRepository repository.CiArtifactRepository) *CommonServiceImpl {
+	ciArtifactRepository repository.CiArtifactRepository,
+	discoveredImageRepository repository.DiscoveredImageRepository,
+	sourceShardService SourceShardService) *CommonServiceImpl {
 	cfg := &CommonServiceConfig{}
 	err := env.Parse(cfg)
 	if err != nil {
 		fmt.Println("failed to parse server cluster status config: " + err.Error())
 	}
 	sourceControllerServiceImpl := &CommonServiceImpl{
-		logger:               logger,
-		config:               cfg,
-		ciArtifactRepository: ciArtifactRepository,
+		logger:                    logger,
+		config:                    cfg,
+		ciArtifactRepository:      ciArtifactRepository,
+		discoveredImageRepository: discoveredImageRepository,
+		sourceShardService:        sourceShardService,
+		httpClient:                &http.Client{Timeout: time.Duration(cfg.WebhookTimeoutSeconds) * time.Second},
 	}
 
 	return sourceControllerServiceImpl
@@ -57,9 +89,346 @@ func (impl *CommonServiceImpl) FilterAlreadyPresentArtifacts(imageDigests []stri
 
 }
 
+// NotifyDiscoveredImages records the digests observed in a source in the discovery ledger
+// and calls the external ci webhook for every digest of the source still pending
+// notification whose retry is due, including the ones whose notification failed in a
+// previous cycle. Digests already present as ci artifacts of the pipeline are not notified again.
+// The push time of the new images is kept, when given, to measure the push to trigger latency.
+// The images delivered by this call are returned.
+func (impl *CommonServiceImpl) NotifyDiscoveredImages(deployConfig bean.DeployConfig, host string, digestTagMap map[string]string, pushedOn map[string]time.Time) ([]*repository.DiscoveredImage, error) {
+	sourceKey := deployConfig.GetSourceKey()
+	err := impl.RecordDiscoveredImages(deployConfig, host, digestTagMap, pushedOn)
+	if err != nil {
+		return nil, err
+	}
+	dueImages, err := impl.discoveredImageRepository.FindDueForNotification(sourceKey, time.Now())
+	if err != nil {
+		impl.logger.Errorw("error in getting pending discovered images", "err", err, "sourceKey", sourceKey)
+		return nil, err
+	}
+	return impl.deliverImages(dueImages)
+}
+
+// RecordDiscoveredImages records the digests observed in a source in the discovery ledger,
+// the new ones pending notification, without delivering them.
+func (impl *CommonServiceImpl) RecordDiscoveredImages(deployConfig bean.DeployConfig, host string, digestTagMap map[string]string, pushedOn map[string]time.Time) error {
+	sourceKey := deployConfig.GetSourceKey()
+	now := time.Now()
+	digests := make([]string, 0, len(digestTagMap))
+	for digest := range digestTagMap {
+		digests = append(digests, digest)
+	}
+	knownImages, err := impl.discoveredImageRepository.FindBySourceKeyAndDigests(sourceKey, digests)
+	if err != nil {
+		impl.logger.Errorw("error in getting discovered images", "err", err, "sourceKey", sourceKey)
+		return err
+	}
+	knownDigests := make([]string, 0, len(knownImages))
+	knownDigestMap := make(map[string]bool, len(knownImages))
+	for _, knownImage := range knownImages {
+		knownDigests = append(knownDigests, knownImage.Digest)
+		knownDigestMap[knownImage.Digest] = true
+	}
+	err = impl.discoveredImageRepository.UpdateLastSeenOn(sourceKey, knownDigests, now)
+	if err != nil {
+		impl.logger.Errorw("error in updating last seen time of discovered images", "err", err, "sourceKey", sourceKey)
+		return err
+	}
+	for digest, tag := range digestTagMap {
+		if knownDigestMap[digest] {
+			continue
+		}
+		discoveredImage := &repository.DiscoveredImage{
+			SourceKey:          sourceKey,
+			ExternalCiId:       deployConfig.ExternalCiId,
+			Image:              bean.ParseImage(host, deployConfig.RepoName, tag),
+			Tag:                tag,
+			Digest:             digest,
+			FirstSeenOn:        now,
+			LastSeenOn:         now,
+			NotificationStatus: repository.NotificationStatusPending,
+			PushedOn:           pushedOn[digest],
+		}
+		err = impl.discoveredImageRepository.Save(discoveredImage)
+		if err != nil {
+			impl.logger.Errorw("error in saving discovered image", "err", err, "sourceKey", sourceKey, "digest", digest)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNotificationStatus returns the state of the digest in the discovery ledger of the
+// source, empty when the digest was never recorded.
+func (impl *CommonServiceImpl) GetNotificationStatus(deployConfig bean.DeployConfig, digest string) (string, error) {
+	sourceKey := deployConfig.GetSourceKey()
+	knownImages, err := impl.discoveredImageRepository.FindBySourceKeyAndDigests(sourceKey, []string{digest})
+	if err != nil {
+		impl.logger.Errorw("error in getting discovered images", "err", err, "sourceKey", sourceKey)
+		return "", err
+	}
+	if len(knownImages) == 0 {
+		return "", nil
+	}
+	return knownImages[0].NotificationStatus, nil
+}
+
+// ReportDiscoveredImages notifies the images discovered in the source, or only plans
+// their notification when the context is a dry run one, and adds the outcome to the report.
+func (impl *CommonServiceImpl) ReportDiscoveredImages(ctx context.Context, deployConfig bean.DeployConfig, host string, report *bean.ReconcileReport) error {
+	if bean.IsDryRun(ctx) {
+		report.DryRun = true
+		plan, err := impl.PlanDiscoveredImages(deployConfig, host, report.DiscoveredDigests)
+		report.Plan = plan
+		return err
+	}
+	notifiedImages, err := impl.NotifyDiscoveredImages(deployConfig, host, report.DiscoveredDigests, report.PushedOn)
+	for _, notifiedImage := range notifiedImages {
+		report.NotifiedDigests = append(report.NotifiedDigests, notifiedImage.Digest)
+	}
+	return err
+}
+
+// GetUnknownDigests returns the digests not yet in the discovery ledger of the source.
+func (impl *CommonServiceImpl) GetUnknownDigests(deployConfig bean.DeployConfig, digests []string) ([]string, error) {
+	sourceKey := deployConfig.GetSourceKey()
+	knownImages, err := impl.discoveredImageRepository.FindBySourceKeyAndDigests(sourceKey, digests)
+	if err != nil {
+		impl.logger.Errorw("error in getting discovered images", "err", err, "sourceKey", sourceKey)
+		return nil, err
+	}
+	knownDigestMap := make(map[string]bool, len(knownImages))
+	for _, knownImage := range knownImages {
+		knownDigestMap[knownImage.Digest] = true
+	}
+	var unknownDigests []string
+	for _, digest := range digests {
+		if !knownDigestMap[digest] {
+			unknownDigests = append(unknownDigests, digest)
+		}
+	}
+	return unknownDigests, nil
+}
+
+// PlanDiscoveredImages returns what NotifyDiscoveredImages would do with the digests
+// discovered in a source, without changing the discovery ledger nor calling the webhook.
+func (impl *CommonServiceImpl) PlanDiscoveredImages(deployConfig bean.DeployConfig, host string, digestTagMap map[string]string) ([]*bean.PlannedNotification, error) {
+	sourceKey := deployConfig.GetSourceKey()
+	digests := make([]string, 0, len(digestTagMap))
+	newDigestTagMap := make(map[string]string, len(digestTagMap))
+	for digest, tag := range digestTagMap {
+		digests = append(digests, digest)
+		newDigestTagMap[digest] = tag
+	}
+	knownImages, err := impl.discoveredImageRepository.FindBySourceKeyAndDigests(sourceKey, digests)
+	if err != nil {
+		impl.logger.Errorw("error in getting discovered images", "err", err, "sourceKey", sourceKey)
+		return nil, err
+	}
+	knownStatusMap := make(map[string]string, len(knownImages))
+	for _, knownImage := range knownImages {
+		knownStatusMap[knownImage.Digest] = knownImage.NotificationStatus
+	}
+	err = impl.FilterAlreadyPresentArtifacts(digests, newDigestTagMap, deployConfig.ExternalCiId)
+	if err != nil {
+		return nil, err
+	}
+	webhookURL := bean.GetParsedWebhookServiceURL(impl.config.ServiceName, impl.config.Namespace, deployConfig.ExternalCiId)
+	plan := make([]*bean.PlannedNotification, 0, len(digestTagMap))
+	for digest, tag := range digestTagMap {
+		_, isNew := newDigestTagMap[digest]
+		plannedNotification := &bean.PlannedNotification{
+			Digest:             digest,
+			Tag:                tag,
+			Image:              bean.ParseImage(host, deployConfig.RepoName, tag),
+			AlreadyPresent:     !isNew,
+			NotificationStatus: knownStatusMap[digest],
+		}
+		status := plannedNotification.NotificationStatus
+		if isNew && (status == "" || status == repository.NotificationStatusPending) {
+			plannedNotification.WebhookURL = webhookURL
+			plannedNotification.Payload = bean.GetPayloadForExternalCi(plannedNotification.Image, digest)
+		}
+		plan = append(plan, plannedNotification)
+	}
+	return plan, nil
+}
+
+// DeliverPendingImages retries the delivery of every pending image whose backoff has
+// elapsed, of the sources owned by the replica.
+func (impl *CommonServiceImpl) DeliverPendingImages() {
+	dueImages, err := impl.discoveredImageRepository.FindDueForNotification("", time.Now())
+	if err != nil {
+		impl.logger.Errorw("error in getting pending discovered images", "err", err)
+		return
+	}
+	var ownedImages []*repository.DiscoveredImage
+	for _, dueImage := range dueImages {
+		if impl.sourceShardService.OwnsSource(dueImage.SourceKey) {
+			ownedImages = append(ownedImages, dueImage)
+		}
+	}
+	_, err = impl.deliverImages(ownedImages)
+	if err != nil {
+		impl.logger.Errorw("error in delivering pending discovered images", "err", err)
+	}
+}
+
+func (impl *CommonServiceImpl) GetDeadLetterImages() ([]*repository.DiscoveredImage, error) {
+	deadLetterImages, err := impl.discoveredImageRepository.FindByStatus(repository.NotificationStatusDeadLetter)
+	if err != nil {
+		impl.logger.Errorw("error in getting dead letter discovered images", "err", err)
+		return nil, err
+	}
+	return deadLetterImages, nil
+}
+
+// ReplayDeadLetterImage moves a dead letter image back to pending with a fresh
+// attempt budget and delivers it right away, or leaves it to the retries of the
+// replica owning its source.
+func (impl *CommonServiceImpl) ReplayDeadLetterImage(id int) (*repository.DiscoveredImage, error) {
+	discoveredImage, err := impl.discoveredImageRepository.FindById(id)
+	if err != nil {
+		impl.logger.Errorw("error in getting discovered image", "err", err, "id", id)
+		return nil, err
+	}
+	if discoveredImage.NotificationStatus != repository.NotificationStatusDeadLetter {
+		return nil, &util.ApiError{
+			HttpStatusCode:  http.StatusConflict,
+			Code:            "409",
+			UserMessage:     fmt.Sprintf("discovered image %d is %s, only dead letter images can be replayed", id, discoveredImage.NotificationStatus),
+			InternalMessage: "discovered image is not in dead letter state",
+		}
+	}
+	discoveredImage.NotificationStatus = repository.NotificationStatusPending
+	discoveredImage.NotificationAttempts = 0
+	discoveredImage.NextAttemptOn = time.Time{}
+	err = impl.discoveredImageRepository.Update(discoveredImage)
+	if err != nil {
+		impl.logger.Errorw("error in updating discovered image", "err", err, "id", id)
+		return nil, err
+	}
+	if !impl.sourceShardService.OwnsSource(discoveredImage.SourceKey) {
+		return discoveredImage, nil
+	}
+	_, err = impl.deliverImages([]*repository.DiscoveredImage{discoveredImage})
+	return discoveredImage, err
+}
+
+// deliveryClaimMargin is added to the webhook timeout to claim an image for its delivery,
+// covering the updates of the image around the call.
+const deliveryClaimMargin = time.Minute
+
+// deliverImages calls the external ci webhook for the given pending images, skipping the
+// ones already present as ci artifacts of their pipeline, and persists the outcome of
+// each delivery. Each image is claimed in the database before its delivery, the images
+// claimed or delivered concurrently, by a reconcile or another replica, are skipped.
+// Failed deliveries are scheduled again with an exponential backoff until they fail
+// permanently or exhaust WEBHOOK_MAX_ATTEMPTS, after which they are moved to the dead
+// letter state. The images delivered are returned.
+func (impl *CommonServiceImpl) deliverImages(images []*repository.DiscoveredImage) ([]*repository.DiscoveredImage, error) {
+	var notifiedImages []*repository.DiscoveredImage
+	if len(images) == 0 {
+		return notifiedImages, nil
+	}
+	if impl.config.DryRun {
+		impl.logger.Infow("dry run, not delivering discovered images", "count", len(images))
+		return notifiedImages, nil
+	}
+	imagesByExternalCiId := make(map[int][]*repository.DiscoveredImage)
+	for _, image := range images {
+		imagesByExternalCiId[image.ExternalCiId] = append(imagesByExternalCiId[image.ExternalCiId], image)
+	}
+	for externalCiId, pipelineImages := range imagesByExternalCiId {
+		digests := make([]string, 0, len(pipelineImages))
+		digestTagMap := make(map[string]string, len(pipelineImages))
+		for _, image := range pipelineImages {
+			digests = append(digests, image.Digest)
+			digestTagMap[image.Digest] = image.Tag
+		}
+		err := impl.FilterAlreadyPresentArtifacts(digests, digestTagMap, externalCiId)
+		if err != nil {
+			return notifiedImages, err
+		}
+		for _, image := range pipelineImages {
+			now := time.Now()
+			claimed, err := impl.discoveredImageRepository.ClaimForNotification(image, now, now.Add(time.Duration(impl.config.WebhookTimeoutSeconds)*time.Second+deliveryClaimMargin))
+			if err != nil {
+				impl.logger.Errorw("error in claiming discovered image", "err", err, "id", image.Id)
+				return notifiedImages, err
+			}
+			if !claimed {
+				continue
+			}
+			if _, ok := digestTagMap[image.Digest]; !ok {
+				image.NotificationStatus = repository.NotificationStatusAlreadyPresent
+			} else if impl.deliverImage(image) {
+				notifiedImages = append(notifiedImages, image)
+			}
+			err = impl.discoveredImageRepository.Update(image)
+			if err != nil {
+				impl.logger.Errorw("error in updating discovered image", "err", err, "sourceKey", image.SourceKey, "digest", image.Digest)
+				return notifiedImages, err
+			}
+		}
+	}
+	return notifiedImages, nil
+}
+
+// deliverImage calls the webhook for the image and tells whether it was delivered.
+func (impl *CommonServiceImpl) deliverImage(image *repository.DiscoveredImage) bool {
+	image.NotificationAttempts++
+	startedOn := time.Now()
+	err := impl.postExternalCIWebHook(image.Image, image.Digest, image.ExternalCiId)
+	duration := time.Since(startedOn)
+	if err == nil {
+		metrics.RecordWebhookDelivery(duration, "")
+		image.NotificationStatus = repository.NotificationStatusNotified
+		image.NotifiedOn = time.Now()
+		impl.recordPushToTrigger(image)
+		image.NextAttemptOn = time.Time{}
+		image.LastStatusCode = http.StatusOK
+		image.LastError = ""
+		return true
+	}
+	impl.logger.Errorw("error in calling external ci webhook", "err", err, "image", image.Image, "digest", image.Digest, "externalCiId", image.ExternalCiId, "attempts", image.NotificationAttempts)
+	image.LastError = err.Error()
+	image.LastStatusCode = 0
+	if webhookErr, ok := err.(*WebhookError); ok {
+		image.LastStatusCode = webhookErr.StatusCode
+	}
+	if !isRetryableWebhookErr(err) || image.NotificationAttempts >= impl.config.WebhookMaxAttempts {
+		metrics.RecordWebhookDelivery(duration, "dead_letter")
+		image.NotificationStatus = repository.NotificationStatusDeadLetter
+		image.NextAttemptOn = time.Time{}
+		return false
+	}
+	metrics.RecordWebhookDelivery(duration, "retry")
+	backoff := getBackoff(image.NotificationAttempts, time.Duration(impl.config.WebhookBackoffBaseSeconds)*time.Second, time.Duration(impl.config.WebhookBackoffMaxSeconds)*time.Second)
+	image.NextAttemptOn = time.Now().Add(backoff)
+	return false
+}
+
+// recordPushToTrigger stores the time from the push of the notified image to the acceptance of
+// the webhook and observes it, unless the image was pushed too long before to be a new push.
+func (impl *CommonServiceImpl) recordPushToTrigger(image *repository.DiscoveredImage) {
+	if image.PushedOn.IsZero() {
+		return
+	}
+	pushToTrigger := image.NotifiedOn.Sub(image.PushedOn)
+	image.PushToTriggerMs = pushToTrigger.Milliseconds()
+	if pushToTrigger >= 0 && pushToTrigger <= time.Duration(impl.config.PushToTriggerMaxSeconds)*time.Second {
+		metrics.PushToTrigger.Observe(pushToTrigger.Seconds())
+	}
+}
+
 // CallExternalCIWebHook will do a http post request using service name and namespace on which orchestrator is running
 func (impl *CommonServiceImpl) CallExternalCIWebHook(digest, tag, host, repoName string, externalCiId int) error {
-	image := bean.ParseImage(host, repoName, tag)
+	return impl.postExternalCIWebHook(bean.ParseImage(host, repoName, tag), digest, externalCiId)
+}
+
+// postExternalCIWebHook returns a *WebhookError when the webhook answers with a non 2xx status code.
+func (impl *CommonServiceImpl) postExternalCIWebHook(image, digest string, externalCiId int) error {
 	url := bean.GetParsedWebhookServiceURL(impl.config.ServiceName, impl.config.Namespace, externalCiId)
 	payload := bean.GetPayloadForExternalCi(image, digest)
 	b, err := json.Marshal(payload)
@@ -73,16 +442,18 @@ func (impl *CommonServiceImpl) CallExternalCIWebHook(digest, tag, host, repoName
 		impl.logger.Errorw("error in new http POST request", "err", err)
 		return err
 	}
-	impl.logger.Infow("cron request", req)
+	impl.logger.Infow("cron request", "url", url, "image", image, "digest", digest)
 	req.Header.Set("api-token", impl.config.ApiToken)
 	req.Header.Add("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := impl.httpClient.Do(req)
 	if err != nil {
 		impl.logger.Errorw("error in hitting http request to web hook", "err", err)
 		return err
 	}
 	defer resp.Body.Close()
-
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &WebhookError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
 	return nil
 }
@@ -0,0 +1,177 @@
+package common
+
+import (
+	"encoding/json"
+	"github.com/devtron-labs/source-controller/bean"
+	repository "github.com/devtron-labs/source-controller/sql/repo"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// stubDiscoveredImageRepository grants the claims of the images not in lostClaims and
+// records the images claimed and updated.
+type stubDiscoveredImageRepository struct {
+	repository.DiscoveredImageRepository
+	lostClaims map[int]bool
+	claimed    []int
+	claimUntil time.Time
+	updated    []repository.DiscoveredImage
+}
+
+func (r *stubDiscoveredImageRepository) ClaimForNotification(discoveredImage *repository.DiscoveredImage, now time.Time, claimUntil time.Time) (bool, error) {
+	if r.lostClaims[discoveredImage.Id] {
+		return false, nil
+	}
+	r.claimed = append(r.claimed, discoveredImage.Id)
+	r.claimUntil = claimUntil
+	return true, nil
+}
+
+func (r *stubDiscoveredImageRepository) Update(discoveredImage *repository.DiscoveredImage) error {
+	r.updated = append(r.updated, *discoveredImage)
+	return nil
+}
+
+// stubCiArtifactRepository answers the digests already present as ci artifacts.
+type stubCiArtifactRepository struct {
+	presentDigests []string
+}
+
+func (r *stubCiArtifactRepository) GetByImages(images []string, externalCiPipelineId int) ([]*repository.CiArtifact, error) {
+	return nil, nil
+}
+
+func (r *stubCiArtifactRepository) GetByImageDigests(imageDigests []string, externalCiPipelineId int) ([]*repository.CiArtifact, error) {
+	var ciArtifacts []*repository.CiArtifact
+	for _, digest := range r.presentDigests {
+		ciArtifacts = append(ciArtifacts, &repository.CiArtifact{ImageDigest: digest})
+	}
+	return ciArtifacts, nil
+}
+
+// webhookTransport sends the webhook calls, addressed to the in cluster service, to the test server.
+type webhookTransport struct {
+	server *url.URL
+}
+
+func (t *webhookTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = t.server.Scheme
+	req.URL.Host = t.server.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestDeliverImages(t *testing.T) {
+	// the webhook answers the digest of the payload with its status code, 200 when not given
+	statusCodes := map[string]int{
+		"sha256:throttled":   http.StatusTooManyRequests,
+		"sha256:unavailable": http.StatusServiceUnavailable,
+		"sha256:invalid":     http.StatusBadRequest,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := &bean.ExternalCI{}
+		if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if statusCode, ok := statusCodes[payload.Digest]; ok {
+			w.WriteHeader(statusCode)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushedOn := time.Now().Add(-time.Minute)
+	image := func(id int, digest string, attempts int) *repository.DiscoveredImage {
+		return &repository.DiscoveredImage{Id: id, SourceKey: "1/devtron/app", ExternalCiId: 1, Image: "docker.io/devtron/app:" + digest[7:],
+			Tag: digest[7:], Digest: digest, NotificationStatus: repository.NotificationStatusPending, NotificationAttempts: attempts, PushedOn: pushedOn}
+	}
+	images := []*repository.DiscoveredImage{
+		image(1, "sha256:delivered", 0),
+		image(2, "sha256:claimed-elsewhere", 0),
+		image(3, "sha256:present", 0),
+		image(4, "sha256:throttled", 2),
+		image(5, "sha256:unavailable", 4),
+		image(6, "sha256:invalid", 0),
+	}
+	discoveredImageRepository := &stubDiscoveredImageRepository{lostClaims: map[int]bool{2: true}}
+	impl := &CommonServiceImpl{
+		logger:                    zap.NewNop().Sugar(),
+		ciArtifactRepository:      &stubCiArtifactRepository{presentDigests: []string{"sha256:present"}},
+		discoveredImageRepository: discoveredImageRepository,
+		config: &CommonServiceConfig{ServiceName: "devtron-service", Namespace: "devtroncd", WebhookTimeoutSeconds: 10,
+			WebhookMaxAttempts: 5, WebhookBackoffBaseSeconds: 2, WebhookBackoffMaxSeconds: 300},
+		httpClient: &http.Client{Transport: &webhookTransport{server: serverUrl}},
+	}
+	startedOn := time.Now()
+	notifiedImages, err := impl.deliverImages(images)
+	if err != nil {
+		t.Fatalf("deliverImages() error = %v", err)
+	}
+
+	if len(notifiedImages) != 1 || notifiedImages[0].Id != 1 {
+		t.Errorf("deliverImages() notified %d images, want the delivered one only", len(notifiedImages))
+	}
+	if claimLease := discoveredImageRepository.claimUntil.Sub(startedOn); claimLease < 10*time.Second+deliveryClaimMargin {
+		t.Errorf("deliverImages() claims the images for %s, want the webhook timeout and the margin", claimLease)
+	}
+	updated := make(map[int]repository.DiscoveredImage, len(discoveredImageRepository.updated))
+	for _, image := range discoveredImageRepository.updated {
+		updated[image.Id] = image
+	}
+	if _, ok := updated[2]; ok || len(updated) != 5 {
+		t.Errorf("deliverImages() updated the images %v, want all but the one claimed by another replica", discoveredImageRepository.claimed)
+	}
+	tests := []struct {
+		id             int
+		wantStatus     string
+		wantAttempts   int
+		wantStatusCode int
+		wantRetry      bool
+	}{
+		{1, repository.NotificationStatusNotified, 1, http.StatusOK, false},
+		{3, repository.NotificationStatusAlreadyPresent, 0, 0, false},
+		// a retryable failure is attempted again after the backoff of its attempts
+		{4, repository.NotificationStatusPending, 3, http.StatusTooManyRequests, true},
+		// the last attempt is dead lettered, as is a permanent failure
+		{5, repository.NotificationStatusDeadLetter, 5, http.StatusServiceUnavailable, false},
+		{6, repository.NotificationStatusDeadLetter, 1, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		image := updated[tt.id]
+		if image.NotificationStatus != tt.wantStatus || image.NotificationAttempts != tt.wantAttempts || image.LastStatusCode != tt.wantStatusCode {
+			t.Errorf("image %s: status %s after %d attempts with %d, want %s after %d attempts with %d", image.Digest, image.NotificationStatus,
+				image.NotificationAttempts, image.LastStatusCode, tt.wantStatus, tt.wantAttempts, tt.wantStatusCode)
+		}
+		if tt.wantRetry != !image.NextAttemptOn.IsZero() {
+			t.Errorf("image %s: next attempt on %s, want a retry %v", image.Digest, image.NextAttemptOn, tt.wantRetry)
+		}
+	}
+	// the third attempt is retried after twice doubled 2s, less a jitter of up to half of it
+	if backoff := updated[4].NextAttemptOn.Sub(startedOn); backoff < 4*time.Second || backoff > 9*time.Second {
+		t.Errorf("deliverImages() retries after %s, want the backoff of the third attempt", backoff)
+	}
+	if delivered := updated[1]; delivered.NotifiedOn.IsZero() || delivered.PushToTriggerMs < time.Minute.Milliseconds() || delivered.LastError != "" {
+		t.Errorf("deliverImages() delivered image = %+v, want its notification and push to trigger time", delivered)
+	}
+	if updated[6].LastError == "" {
+		t.Error("deliverImages() left the error of the dead lettered image unset")
+	}
+}
+
+func TestDeliverImages_DryRun(t *testing.T) {
+	discoveredImageRepository := &stubDiscoveredImageRepository{}
+	impl := &CommonServiceImpl{logger: zap.NewNop().Sugar(), discoveredImageRepository: discoveredImageRepository, config: &CommonServiceConfig{DryRun: true}}
+	notifiedImages, err := impl.deliverImages([]*repository.DiscoveredImage{{Id: 1, Digest: "sha256:a"}})
+	if err != nil || len(notifiedImages) != 0 || len(discoveredImageRepository.claimed) != 0 {
+		t.Errorf("deliverImages() in dry run = %v, %v, claimed %v, want nothing delivered", notifiedImages, err, discoveredImageRepository.claimed)
+	}
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/config"
+	"github.com/devtron-labs/source-controller/registry"
+	repository "github.com/devtron-labs/source-controller/sql/repo"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ExternalCiSourceService interface {
+	GetDeployConfigs() ([]bean.DeployConfig, error)
+}
+
+type ExternalCiSourceServiceImpl struct {
+	logger                     *zap.SugaredLogger
+	externalCiSourceRepository repository.ExternalCiSourceRepository
+	config                     *ExternalCiSourceConfig
+	// deployConfigs are the sources last read from the orchestrator, on readOn, guarded by lock
+	deployConfigs []bean.DeployConfig
+	readOn        time.Time
+	lock          sync.Mutex
+}
+
+type ExternalCiSourceConfig struct {
+	// CacheSeconds is how long the sources read from the orchestrator are reused, they are
+	// needed on every scheduler tick and every push event
+	CacheSeconds int `env:"EXTERNAL_CI_SOURCES_CACHE_SECONDS" envDefault:"30"`
+}
+
+func NewExternalCiSourceServiceImpl(logger *zap.SugaredLogger,
+	externalCiSourceRepository repository.ExternalCiSourceRepository) (*ExternalCiSourceServiceImpl, error) {
+	cfg := &ExternalCiSourceConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing external ci source config", "err", err)
+		return nil, err
+	}
+	return &ExternalCiSourceServiceImpl{
+		logger:                     logger,
+		externalCiSourceRepository: externalCiSourceRepository,
+		config:                     cfg,
+	}, nil
+}
+
+// GetDeployConfigs returns a source for every active external ci pipeline of the
+// orchestrator, watching the repository of the ci template of its app with the
+// credentials of the container registry it is bound to. The pipelines whose source
+// is invalid are logged and left out. The sources are read again from the orchestrator
+// once older than EXTERNAL_CI_SOURCES_CACHE_SECONDS.
+func (impl *ExternalCiSourceServiceImpl) GetDeployConfigs() ([]bean.DeployConfig, error) {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+	if impl.deployConfigs != nil && time.Since(impl.readOn) < time.Duration(impl.config.CacheSeconds)*time.Second {
+		return append([]bean.DeployConfig(nil), impl.deployConfigs...), nil
+	}
+	externalCiSources, err := impl.externalCiSourceRepository.FindAllActive()
+	if err != nil {
+		impl.logger.Errorw("error in getting external ci sources", "err", err)
+		return nil, err
+	}
+	deployConfigs := make([]bean.DeployConfig, 0, len(externalCiSources))
+	for _, externalCiSource := range externalCiSources {
+		deployConfig := getDeployConfig(externalCiSource)
+		if err := config.ValidateDeployConfig(deployConfig); err != nil {
+			impl.logger.Errorw("skipping invalid external ci source", "err", err, "externalCiId", externalCiSource.ExternalCiId, "appId", externalCiSource.AppId)
+			continue
+		}
+		deployConfigs = append(deployConfigs, deployConfig)
+	}
+	impl.deployConfigs = deployConfigs
+	impl.readOn = time.Now()
+	return append([]bean.DeployConfig(nil), deployConfigs...), nil
+}
+
+func getDeployConfig(externalCiSource *repository.ExternalCiSource) bean.DeployConfig {
+	registryURL := strings.TrimSuffix(externalCiSource.RegistryURL, "/")
+	registryURL = strings.TrimPrefix(strings.TrimPrefix(registryURL, "https://"), "http://")
+	deployConfig := bean.DeployConfig{
+		ExternalCiId: externalCiSource.ExternalCiId,
+		RepoName:     externalCiSource.RepoName,
+		RegistryURL:  registryURL,
+		RegistryType: externalCiSource.RegistryType,
+	}
+	if externalCiSource.RegistryType == registry.REGISTRYTYPE_ECR {
+		deployConfig.AwsRegion = externalCiSource.AwsRegion
+		deployConfig.AwsAccessKeyId = externalCiSource.AwsAccessKeyId
+		deployConfig.AwsSecretAccessKey = externalCiSource.AwsSecretAccessKey
+		// <registry id>.dkr.ecr.<region>.amazonaws.com
+		if host := strings.Split(registryURL, "/")[0]; strings.Contains(host, ".dkr.ecr.") {
+			deployConfig.AwsRegistryId = strings.Split(host, ".")[0]
+		}
+		return deployConfig
+	}
+	switch deployConfig.RegistryType {
+	case registry.REGISTRYTYPE_DOCKER_HUB, registry.REGISTRYTYPE_GCR, registry.REGISTRYTYPE_ARTIFACT_REGISTRY:
+	default:
+		// other registry types of the orchestrator (acr, quay...) are plain registries for the controller
+		deployConfig.RegistryType = ""
+	}
+	deployConfig.Username = externalCiSource.Username
+	deployConfig.Password = externalCiSource.Password
+	return deployConfig
+}
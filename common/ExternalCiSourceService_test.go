@@ -0,0 +1,54 @@
+package common
+
+import (
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/registry"
+	repository "github.com/devtron-labs/source-controller/sql/repo"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+)
+
+type stubExternalCiSourceRepository struct {
+	externalCiSources []*repository.ExternalCiSource
+	calls             int
+}
+
+func (r *stubExternalCiSourceRepository) FindAllActive() ([]*repository.ExternalCiSource, error) {
+	r.calls++
+	return r.externalCiSources, nil
+}
+
+func TestExternalCiSourceService_GetDeployConfigs(t *testing.T) {
+	externalCiSourceRepository := &stubExternalCiSourceRepository{externalCiSources: []*repository.ExternalCiSource{
+		{ExternalCiId: 1, RepoName: "devtron/app", RegistryURL: "https://index.docker.io/", RegistryType: registry.REGISTRYTYPE_DOCKER_HUB,
+			Username: "devtron", Password: "s3cret"},
+		{ExternalCiId: 2, RepoName: "devtron/ecr-app", RegistryURL: "445808685819.dkr.ecr.us-east-2.amazonaws.com", RegistryType: registry.REGISTRYTYPE_ECR,
+			AwsRegion: "us-east-2", AwsAccessKeyId: "AKIA", AwsSecretAccessKey: "secret", Username: "ignored"},
+		{ExternalCiId: 3, RepoName: "devtron/acr-app", RegistryURL: "http://devtron.azurecr.io", RegistryType: "acr",
+			Username: "devtron", Password: "s3cret"},
+		// no registry url, the source is left out
+		{ExternalCiId: 4, RepoName: "devtron/broken", RegistryType: registry.REGISTRYTYPE_DOCKER_HUB},
+	}}
+	impl := &ExternalCiSourceServiceImpl{
+		logger:                     zap.NewNop().Sugar(),
+		externalCiSourceRepository: externalCiSourceRepository,
+		config:                     &ExternalCiSourceConfig{CacheSeconds: 30},
+	}
+	deployConfigs, err := impl.GetDeployConfigs()
+	if err != nil {
+		t.Fatalf("GetDeployConfigs() error = %v", err)
+	}
+	dockerHub := bean.DeployConfig{ExternalCiId: 1, RepoName: "devtron/app", RegistryURL: "index.docker.io", RegistryType: registry.REGISTRYTYPE_DOCKER_HUB}
+	dockerHub.Username, dockerHub.Password = "devtron", "s3cret"
+	ecr := bean.DeployConfig{ExternalCiId: 2, RepoName: "devtron/ecr-app", RegistryURL: "445808685819.dkr.ecr.us-east-2.amazonaws.com", RegistryType: registry.REGISTRYTYPE_ECR}
+	ecr.AwsRegion, ecr.AwsRegistryId, ecr.AwsAccessKeyId, ecr.AwsSecretAccessKey = "us-east-2", "445808685819", "AKIA", "secret"
+	acr := bean.DeployConfig{ExternalCiId: 3, RepoName: "devtron/acr-app", RegistryURL: "devtron.azurecr.io"}
+	acr.Username, acr.Password = "devtron", "s3cret"
+	if want := []bean.DeployConfig{dockerHub, ecr, acr}; !reflect.DeepEqual(deployConfigs, want) {
+		t.Errorf("GetDeployConfigs() = %+v, want %+v", deployConfigs, want)
+	}
+	if _, err = impl.GetDeployConfigs(); err != nil || externalCiSourceRepository.calls != 1 {
+		t.Errorf("GetDeployConfigs() read the orchestrator %d times within the cache duration, want 1", externalCiSourceRepository.calls)
+	}
+}
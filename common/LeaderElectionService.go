@@ -0,0 +1,154 @@
+package common
+
+import (
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/internal/metrics"
+	"github.com/go-pg/pg"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LeaderElectionService elects, among the replicas sharing the database, the one
+// reconciling the sources and delivering the webhooks, through a postgres advisory lock
+// held by a dedicated connection. The lock is released by postgres when the connection
+// of the leader is lost, the standby replicas keep serving the read api meanwhile.
+type LeaderElectionService interface {
+	IsEnabled() bool
+	IsLeader() bool
+	Start()
+	Stop()
+}
+
+type LeaderElectionServiceImpl struct {
+	logger       *zap.SugaredLogger
+	dbConnection *pg.DB
+	config       *LeaderElectionConfig
+	isLeader     atomic.Bool
+	// conn holds the advisory lock while leader, guarded by lock
+	conn *pg.Conn
+	lock sync.Mutex
+	stop chan struct{}
+}
+
+type LeaderElectionConfig struct {
+	// Enabled elects a leader among the replicas, every replica reconciles when disabled
+	Enabled              bool  `env:"LEADER_ELECTION_ENABLED" envDefault:"false"`
+	LockId               int64 `env:"LEADER_ELECTION_LOCK_ID" envDefault:"7283402"`
+	RetryIntervalSeconds int   `env:"LEADER_ELECTION_RETRY_INTERVAL_SECONDS" envDefault:"5"`
+}
+
+func NewLeaderElectionServiceImpl(logger *zap.SugaredLogger, dbConnection *pg.DB) (*LeaderElectionServiceImpl, error) {
+	cfg := &LeaderElectionConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing leader election config", "err", err)
+		return nil, err
+	}
+	return &LeaderElectionServiceImpl{
+		logger:       logger,
+		dbConnection: dbConnection,
+		config:       cfg,
+		stop:         make(chan struct{}),
+	}, nil
+}
+
+func (impl *LeaderElectionServiceImpl) IsEnabled() bool {
+	return impl.config.Enabled
+}
+
+func (impl *LeaderElectionServiceImpl) IsLeader() bool {
+	return impl.isLeader.Load()
+}
+
+// Start campaigns for the leadership in the background, a replica is always
+// the leader when the election is disabled.
+func (impl *LeaderElectionServiceImpl) Start() {
+	if !impl.config.Enabled {
+		impl.setLeader(true)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(impl.config.RetryIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			impl.campaign()
+			select {
+			case <-impl.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// campaign tries to take the lock when standby, and checks that it is still held when leader.
+func (impl *LeaderElectionServiceImpl) campaign() {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+	if impl.conn != nil {
+		if impl.holdsLock(impl.conn) {
+			return
+		}
+		impl.logger.Warnw("lost leadership, the advisory lock is no longer held", "lockId", impl.config.LockId)
+		impl.releaseConn()
+		return
+	}
+	conn := impl.dbConnection.Conn()
+	var acquired bool
+	_, err := conn.QueryOne(pg.Scan(&acquired), `SELECT pg_try_advisory_lock(?)`, impl.config.LockId)
+	if err != nil || !acquired {
+		if err != nil {
+			impl.logger.Errorw("error in acquiring leader advisory lock", "err", err, "lockId", impl.config.LockId)
+		}
+		_ = conn.Close()
+		return
+	}
+	impl.conn = conn
+	impl.setLeader(true)
+	impl.logger.Infow("acquired leadership", "lockId", impl.config.LockId)
+}
+
+// holdsLock checks the lock is held by the session of the connection, a connection
+// re-established after a failure would answer without holding it.
+func (impl *LeaderElectionServiceImpl) holdsLock(conn *pg.Conn) bool {
+	var held bool
+	_, err := conn.QueryOne(pg.Scan(&held), `SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory' AND granted
+		AND pid = pg_backend_pid() AND ((classid::bigint << 32) | objid::bigint) = ?)`, impl.config.LockId)
+	if err != nil {
+		impl.logger.Errorw("error in checking leader advisory lock", "err", err, "lockId", impl.config.LockId)
+		return false
+	}
+	return held
+}
+
+func (impl *LeaderElectionServiceImpl) releaseConn() {
+	impl.setLeader(false)
+	_, _ = impl.conn.Exec(`SELECT pg_advisory_unlock(?)`, impl.config.LockId)
+	_ = impl.conn.Close()
+	impl.conn = nil
+}
+
+func (impl *LeaderElectionServiceImpl) setLeader(isLeader bool) {
+	impl.isLeader.Store(isLeader)
+	if isLeader {
+		metrics.Leader.Set(1)
+	} else {
+		metrics.Leader.Set(0)
+	}
+}
+
+// Stop gives up the leadership so that a standby replica takes over right away.
+func (impl *LeaderElectionServiceImpl) Stop() {
+	if !impl.config.Enabled {
+		return
+	}
+	close(impl.stop)
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+	if impl.conn != nil {
+		impl.releaseConn()
+		impl.logger.Infow("released leadership", "lockId", impl.config.LockId)
+	}
+}
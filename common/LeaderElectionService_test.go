@@ -0,0 +1,29 @@
+package common
+
+import (
+	"github.com/go-pg/pg"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestLeaderElectionService_Disabled(t *testing.T) {
+	impl := &LeaderElectionServiceImpl{logger: zap.NewNop().Sugar(), config: &LeaderElectionConfig{}, stop: make(chan struct{})}
+	impl.Start()
+	if !impl.IsLeader() {
+		t.Error("a replica is not the leader with the election disabled")
+	}
+	impl.Stop()
+	if !impl.IsLeader() {
+		t.Error("Stop() gave up the leadership with the election disabled")
+	}
+}
+
+func TestLeaderElectionService_CampaignWithoutDatabase(t *testing.T) {
+	dbConnection := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", User: "postgres", MaxRetries: 0})
+	defer dbConnection.Close()
+	impl := &LeaderElectionServiceImpl{logger: zap.NewNop().Sugar(), dbConnection: dbConnection, config: &LeaderElectionConfig{Enabled: true, LockId: 7283402}}
+	impl.campaign()
+	if impl.IsLeader() || impl.conn != nil {
+		t.Error("campaign() took the leadership without reaching the database")
+	}
+}
@@ -0,0 +1,202 @@
+package common
+
+import (
+	"errors"
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/internal/metrics"
+	repository "github.com/devtron-labs/source-controller/sql/repo"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+)
+
+// SourceShardService tells which replica owns a source, only the owner reconciles it
+// and delivers its webhooks. When sharding is enabled the replicas heartbeat in a
+// membership table and every source is owned by one of the alive replicas through
+// rendezvous hashing, so that the sources of a dead replica are spread over the others
+// and only those move. Otherwise the leader, or the only replica, owns every source.
+type SourceShardService interface {
+	OwnsSource(sourceKey string) bool
+	GetOwner(sourceKey string) string
+	GetReplicaId() string
+	Start()
+	Stop()
+}
+
+type SourceShardServiceImpl struct {
+	logger                *zap.SugaredLogger
+	replicaRepository     repository.ReplicaRepository
+	leaderElectionService LeaderElectionService
+	config                *SourceShardConfig
+	replicaId             string
+	startedOn             time.Time
+	// members are the ids of the alive replicas, valid until membersValidUntil, guarded by lock
+	members           []string
+	membersValidUntil time.Time
+	lock              sync.RWMutex
+	stop              chan struct{}
+}
+
+type SourceShardConfig struct {
+	// Enabled spreads the sources over the replicas, it excludes LEADER_ELECTION_ENABLED
+	Enabled                  bool `env:"SHARDING_ENABLED" envDefault:"false"`
+	HeartbeatIntervalSeconds int  `env:"SHARDING_HEARTBEAT_INTERVAL_SECONDS" envDefault:"10"`
+	// MemberTtlSeconds is the time after its last heartbeat after which a replica is considered dead
+	MemberTtlSeconds int `env:"SHARDING_MEMBER_TTL_SECONDS" envDefault:"30"`
+	// ReplicaId identifies the replica, the pod name is used when not set
+	ReplicaId string `env:"REPLICA_ID" envDefault:""`
+}
+
+func NewSourceShardServiceImpl(logger *zap.SugaredLogger,
+	replicaRepository repository.ReplicaRepository,
+	leaderElectionService LeaderElectionService) (*SourceShardServiceImpl, error) {
+	cfg := &SourceShardConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing sharding config", "err", err)
+		return nil, err
+	}
+	if cfg.Enabled && leaderElectionService.IsEnabled() {
+		return nil, errors.New("SHARDING_ENABLED and LEADER_ELECTION_ENABLED cannot be both set, sharding already prevents two replicas from reconciling a source")
+	}
+	replicaId := cfg.ReplicaId
+	if replicaId == "" {
+		replicaId, err = os.Hostname()
+		if err != nil {
+			logger.Errorw("error in getting hostname for the replica id", "err", err)
+			return nil, err
+		}
+	}
+	return &SourceShardServiceImpl{
+		logger:                logger,
+		replicaRepository:     replicaRepository,
+		leaderElectionService: leaderElectionService,
+		config:                cfg,
+		replicaId:             replicaId,
+		startedOn:             time.Now(),
+		stop:                  make(chan struct{}),
+	}, nil
+}
+
+func (impl *SourceShardServiceImpl) GetReplicaId() string {
+	return impl.replicaId
+}
+
+// OwnsSource tells whether this replica reconciles the source. With sharding, a replica
+// whose membership could not be refreshed within the ttl owns no source, as the
+// other replicas have taken over its sources by then.
+func (impl *SourceShardServiceImpl) OwnsSource(sourceKey string) bool {
+	if !impl.config.Enabled {
+		return impl.leaderElectionService.IsLeader()
+	}
+	return impl.GetOwner(sourceKey) == impl.replicaId
+}
+
+// GetOwner returns the id of the replica owning the source, empty when unknown.
+func (impl *SourceShardServiceImpl) GetOwner(sourceKey string) string {
+	if !impl.config.Enabled {
+		if impl.leaderElectionService.IsLeader() {
+			return impl.replicaId
+		}
+		return ""
+	}
+	impl.lock.RLock()
+	defer impl.lock.RUnlock()
+	if time.Now().After(impl.membersValidUntil) {
+		return ""
+	}
+	return getRendezvousOwner(impl.members, sourceKey)
+}
+
+// getRendezvousOwner returns the member with the highest hash of itself and the source.
+func getRendezvousOwner(members []string, sourceKey string) string {
+	var owner string
+	var ownerScore uint64
+	for _, member := range members {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(member))
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(sourceKey))
+		if score := hash.Sum64(); owner == "" || score > ownerScore {
+			owner, ownerScore = member, score
+		}
+	}
+	return owner
+}
+
+// Start joins the membership and keeps heartbeating in the background.
+func (impl *SourceShardServiceImpl) Start() {
+	if !impl.config.Enabled {
+		return
+	}
+	impl.heartbeat()
+	go func() {
+		ticker := time.NewTicker(time.Duration(impl.config.HeartbeatIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-impl.stop:
+				return
+			case <-ticker.C:
+				impl.heartbeat()
+			}
+		}
+	}()
+}
+
+// heartbeat refreshes the membership of the replica and the list of the alive replicas,
+// the replicas dead for longer than the ttl are removed from the membership.
+func (impl *SourceShardServiceImpl) heartbeat() {
+	now := time.Now()
+	ttl := time.Duration(impl.config.MemberTtlSeconds) * time.Second
+	err := impl.replicaRepository.Heartbeat(&repository.Replica{ReplicaId: impl.replicaId, StartedOn: impl.startedOn, HeartbeatOn: now})
+	if err != nil {
+		impl.logger.Errorw("error in heartbeating replica membership", "err", err, "replicaId", impl.replicaId)
+		return
+	}
+	if err = impl.replicaRepository.DeleteStale(now.Add(-ttl)); err != nil {
+		impl.logger.Errorw("error in removing dead replicas from membership", "err", err)
+	}
+	replicas, err := impl.replicaRepository.FindAlive(now.Add(-ttl))
+	if err != nil {
+		impl.logger.Errorw("error in getting alive replicas", "err", err)
+		return
+	}
+	members := make([]string, 0, len(replicas))
+	for _, replica := range replicas {
+		members = append(members, replica.ReplicaId)
+	}
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+	if !equalMembers(impl.members, members) {
+		impl.logger.Infow("replica membership changed, rebalancing sources", "replicaId", impl.replicaId, "members", members)
+	}
+	impl.members = members
+	impl.membersValidUntil = now.Add(ttl)
+	metrics.ShardMembers.Set(float64(len(members)))
+}
+
+func equalMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Stop leaves the membership so that the other replicas take over the sources right away.
+func (impl *SourceShardServiceImpl) Stop() {
+	if !impl.config.Enabled {
+		return
+	}
+	close(impl.stop)
+	if err := impl.replicaRepository.Delete(impl.replicaId); err != nil {
+		impl.logger.Errorw("error in leaving replica membership", "err", err, "replicaId", impl.replicaId)
+	}
+}
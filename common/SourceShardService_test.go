@@ -0,0 +1,142 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	repository "github.com/devtron-labs/source-controller/sql/repo"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetRendezvousOwner(t *testing.T) {
+	members := []string{"source-controller-0", "source-controller-1", "source-controller-2"}
+	owners := make(map[string]string)
+	ownedCount := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		sourceKey := fmt.Sprintf("%d/docker.io/devtron/app-%d", i, i)
+		owners[sourceKey] = getRendezvousOwner(members, sourceKey)
+		ownedCount[owners[sourceKey]]++
+	}
+	for _, member := range members {
+		if ownedCount[member] < 50 {
+			t.Errorf("member %s owns %d of 300 sources, expected a fair share", member, ownedCount[member])
+		}
+	}
+	// once a member leaves, only its sources move
+	remaining := members[:2]
+	for sourceKey, owner := range owners {
+		newOwner := getRendezvousOwner(remaining, sourceKey)
+		if owner != members[2] && newOwner != owner {
+			t.Errorf("source %s moved from %s to %s though its owner is still alive", sourceKey, owner, newOwner)
+		}
+	}
+	if owner := getRendezvousOwner(nil, "1/docker.io/devtron/app"); owner != "" {
+		t.Errorf("expected no owner without members, got %s", owner)
+	}
+}
+
+// stubReplicaRepository keeps the replicas in memory, failing the heartbeats while err is set.
+type stubReplicaRepository struct {
+	replicas map[string]*repository.Replica
+	err      error
+}
+
+func (r *stubReplicaRepository) Heartbeat(replica *repository.Replica) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.replicas[replica.ReplicaId] = replica
+	return nil
+}
+
+func (r *stubReplicaRepository) FindAlive(since time.Time) ([]*repository.Replica, error) {
+	var replicas []*repository.Replica
+	for _, replicaId := range []string{"source-controller-0", "source-controller-1", "source-controller-2"} {
+		if replica, ok := r.replicas[replicaId]; ok && replica.HeartbeatOn.After(since) {
+			replicas = append(replicas, replica)
+		}
+	}
+	return replicas, nil
+}
+
+func (r *stubReplicaRepository) DeleteStale(before time.Time) error {
+	for replicaId, replica := range r.replicas {
+		if replica.HeartbeatOn.Before(before) {
+			delete(r.replicas, replicaId)
+		}
+	}
+	return nil
+}
+
+func (r *stubReplicaRepository) Delete(replicaId string) error {
+	delete(r.replicas, replicaId)
+	return nil
+}
+
+// stubLeaderElectionService is the leader as told.
+type stubLeaderElectionService struct {
+	leader bool
+}
+
+func (s *stubLeaderElectionService) IsEnabled() bool { return true }
+func (s *stubLeaderElectionService) IsLeader() bool  { return s.leader }
+func (s *stubLeaderElectionService) Start()          {}
+func (s *stubLeaderElectionService) Stop()           {}
+
+func TestSourceShardService_Heartbeat(t *testing.T) {
+	now := time.Now()
+	replicaRepository := &stubReplicaRepository{replicas: map[string]*repository.Replica{
+		"source-controller-1": {ReplicaId: "source-controller-1", HeartbeatOn: now.Add(-5 * time.Second)},
+		// dead for longer than the ttl
+		"source-controller-2": {ReplicaId: "source-controller-2", HeartbeatOn: now.Add(-time.Minute)},
+	}}
+	impl := &SourceShardServiceImpl{
+		logger:            zap.NewNop().Sugar(),
+		replicaRepository: replicaRepository,
+		config:            &SourceShardConfig{Enabled: true, HeartbeatIntervalSeconds: 10, MemberTtlSeconds: 30},
+		replicaId:         "source-controller-0",
+		startedOn:         now,
+	}
+	impl.heartbeat()
+	wantMembers := []string{"source-controller-0", "source-controller-1"}
+	if !reflect.DeepEqual(impl.members, wantMembers) {
+		t.Errorf("heartbeat() members = %v, want the alive replicas %v", impl.members, wantMembers)
+	}
+	if _, ok := replicaRepository.replicas["source-controller-2"]; ok {
+		t.Error("heartbeat() kept the dead replica in the membership")
+	}
+	sourceKey := "1/docker.io/devtron/app"
+	owner := impl.GetOwner(sourceKey)
+	if owner != getRendezvousOwner(wantMembers, sourceKey) || impl.OwnsSource(sourceKey) != (owner == "source-controller-0") {
+		t.Errorf("GetOwner() = %s, want the rendezvous owner among the alive replicas", owner)
+	}
+
+	// a failed heartbeat keeps the members until the ttl, after which the replica owns no source
+	replicaRepository.err = errors.New("connection refused")
+	validUntil := impl.membersValidUntil
+	impl.heartbeat()
+	if !impl.membersValidUntil.Equal(validUntil) || impl.GetOwner(sourceKey) != owner {
+		t.Errorf("heartbeat() failing extended the members until %s or changed the owner", impl.membersValidUntil)
+	}
+	impl.membersValidUntil = time.Now().Add(-time.Second)
+	for _, replicaId := range wantMembers {
+		impl.replicaId = replicaId
+		if impl.OwnsSource(sourceKey) || impl.GetOwner(sourceKey) != "" {
+			t.Errorf("replica %s owns a source with members past their ttl", replicaId)
+		}
+	}
+}
+
+func TestSourceShardService_Disabled(t *testing.T) {
+	leaderElectionService := &stubLeaderElectionService{}
+	impl := &SourceShardServiceImpl{config: &SourceShardConfig{}, leaderElectionService: leaderElectionService, replicaId: "source-controller-0"}
+	if impl.OwnsSource("1/docker.io/devtron/app") || impl.GetOwner("1/docker.io/devtron/app") != "" {
+		t.Error("a standby replica owns a source without sharding")
+	}
+	leaderElectionService.leader = true
+	if !impl.OwnsSource("1/docker.io/devtron/app") || impl.GetOwner("1/docker.io/devtron/app") != "source-controller-0" {
+		t.Error("the leader does not own every source without sharding")
+	}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/internal/util"
+	"github.com/devtron-labs/source-controller/registry"
+	repository "github.com/devtron-labs/source-controller/sql/repo"
+	"go.uber.org/zap"
+	"time"
+)
+
+type SourceStatusService interface {
+	RegisterSources(deployConfigs []bean.DeployConfig) error
+	RecordReconcile(deployConfig bean.DeployConfig, startedOn time.Time, report *bean.ReconcileReport, reconcileErr error)
+	GetSourceStatuses() ([]*repository.SourceStatus, error)
+	GetSourceStatusesByExternalCiId(externalCiId int) ([]*repository.SourceStatus, error)
+}
+
+type SourceStatusServiceImpl struct {
+	logger                 *zap.SugaredLogger
+	sourceStatusRepository repository.SourceStatusRepository
+	sourceShardService     SourceShardService
+}
+
+func NewSourceStatusServiceImpl(logger *zap.SugaredLogger,
+	sourceStatusRepository repository.SourceStatusRepository,
+	sourceShardService SourceShardService) *SourceStatusServiceImpl {
+	return &SourceStatusServiceImpl{
+		logger:                 logger,
+		sourceStatusRepository: sourceStatusRepository,
+		sourceShardService:     sourceShardService,
+	}
+}
+
+// RegisterSources marks the given sources as the active ones, so that they are listed
+// even before their first reconciliation, and deactivates the sources no longer configured.
+func (impl *SourceStatusServiceImpl) RegisterSources(deployConfigs []bean.DeployConfig) error {
+	sourceKeys := make([]string, 0, len(deployConfigs))
+	for _, deployConfig := range deployConfigs {
+		sourceKey := deployConfig.GetSourceKey()
+		sourceKeys = append(sourceKeys, sourceKey)
+		sourceStatus, err := impl.sourceStatusRepository.FindBySourceKey(sourceKey)
+		if err != nil && !util.IsErrNoRows(err) {
+			impl.logger.Errorw("error in getting source status", "err", err, "sourceKey", sourceKey)
+			return err
+		}
+		if err == nil && sourceStatus.Active {
+			continue
+		}
+		sourceStatus.SourceKey = sourceKey
+		sourceStatus.ExternalCiId = deployConfig.ExternalCiId
+		sourceStatus.RegistryURL = deployConfig.RegistryURL
+		sourceStatus.RepoName = deployConfig.RepoName
+		sourceStatus.Active = true
+		sourceStatus.UpdatedOn = time.Now()
+		err = impl.sourceStatusRepository.Upsert(sourceStatus)
+		if err != nil {
+			impl.logger.Errorw("error in saving source status", "err", err, "sourceKey", sourceKey)
+			return err
+		}
+	}
+	err := impl.sourceStatusRepository.DeactivateAllExcept(sourceKeys)
+	if err != nil {
+		impl.logger.Errorw("error in deactivating removed sources", "err", err)
+	}
+	return err
+}
+
+// RecordReconcile persists the outcome of a reconciliation of the source, the time of
+// the last success is kept when it failed and the end of the backoff of the registry
+// host is recorded when it was throttled.
+func (impl *SourceStatusServiceImpl) RecordReconcile(deployConfig bean.DeployConfig, startedOn time.Time, report *bean.ReconcileReport, reconcileErr error) {
+	sourceKey := deployConfig.GetSourceKey()
+	sourceStatus, err := impl.sourceStatusRepository.FindBySourceKey(sourceKey)
+	if err != nil && !util.IsErrNoRows(err) {
+		impl.logger.Errorw("error in getting source status", "err", err, "sourceKey", sourceKey)
+		return
+	}
+	now := time.Now()
+	sourceStatus.SourceKey = sourceKey
+	sourceStatus.ExternalCiId = deployConfig.ExternalCiId
+	sourceStatus.RegistryURL = deployConfig.RegistryURL
+	sourceStatus.RepoName = deployConfig.RepoName
+	sourceStatus.Active = true
+	sourceStatus.LastAttemptOn = startedOn
+	sourceStatus.LastDurationMs = now.Sub(startedOn).Milliseconds()
+	sourceStatus.ReconciledBy = impl.sourceShardService.GetReplicaId()
+	sourceStatus.UpdatedOn = now
+	if report != nil {
+		sourceStatus.TagCount = report.TagCount
+		sourceStatus.DiscoveredCount = len(report.DiscoveredDigests)
+		sourceStatus.NotifiedCount = len(report.NotifiedDigests)
+	}
+	sourceStatus.ThrottledUntil = time.Time{}
+	if throttledErr, ok := registry.AsThrottledError(reconcileErr); ok {
+		sourceStatus.ThrottledUntil = throttledErr.Until
+	}
+	if reconcileErr != nil {
+		sourceStatus.LastError = reconcileErr.Error()
+	} else {
+		sourceStatus.LastSuccessOn = now
+		sourceStatus.LastError = ""
+	}
+	err = impl.sourceStatusRepository.Upsert(sourceStatus)
+	if err != nil {
+		impl.logger.Errorw("error in saving source status", "err", err, "sourceKey", sourceKey)
+	}
+}
+
+func (impl *SourceStatusServiceImpl) GetSourceStatuses() ([]*repository.SourceStatus, error) {
+	sourceStatuses, err := impl.sourceStatusRepository.FindActive()
+	if err != nil {
+		impl.logger.Errorw("error in getting source statuses", "err", err)
+		return nil, err
+	}
+	impl.setOwners(sourceStatuses)
+	return sourceStatuses, nil
+}
+
+func (impl *SourceStatusServiceImpl) GetSourceStatusesByExternalCiId(externalCiId int) ([]*repository.SourceStatus, error) {
+	sourceStatuses, err := impl.sourceStatusRepository.FindActiveByExternalCiId(externalCiId)
+	if err != nil {
+		impl.logger.Errorw("error in getting source statuses", "err", err, "externalCiId", externalCiId)
+		return nil, err
+	}
+	impl.setOwners(sourceStatuses)
+	return sourceStatuses, nil
+}
+
+// setOwners sets the replica owning each source, as seen by this replica.
+func (impl *SourceStatusServiceImpl) setOwners(sourceStatuses []*repository.SourceStatus) {
+	for _, sourceStatus := range sourceStatuses {
+		sourceStatus.Owner = impl.sourceShardService.GetOwner(sourceStatus.SourceKey)
+	}
+}
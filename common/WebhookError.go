@@ -0,0 +1,55 @@
+package common
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// WebhookError is returned when the external ci webhook answers with a non 2xx status code.
+type WebhookError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *WebhookError) Error() string {
+	return fmt.Sprintf("external ci webhook responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsRetryable tells whether the call may succeed later: timeouts, throttling and
+// server errors are retried, any other client error is permanent.
+func (e *WebhookError) IsRetryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return e.StatusCode >= http.StatusInternalServerError
+}
+
+// isRetryableWebhookErr tells whether a failed delivery should be attempted again,
+// errors without a status code, like connection failures, are always retried.
+func isRetryableWebhookErr(err error) bool {
+	if webhookErr, ok := err.(*WebhookError); ok {
+		return webhookErr.IsRetryable()
+	}
+	return true
+}
+
+// getBackoff returns the delay before the next delivery attempt: the base delay
+// doubled for each failed attempt, capped to max, with a random jitter taking
+// off up to half of it so that deliveries failed together are not retried together.
+func getBackoff(attempts int, base, max time.Duration) time.Duration {
+	backoff := base
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableWebhookErr(t *testing.T) {
+	cases := map[error]bool{
+		&WebhookError{StatusCode: 400}:   false,
+		&WebhookError{StatusCode: 404}:   false,
+		&WebhookError{StatusCode: 408}:   true,
+		&WebhookError{StatusCode: 429}:   true,
+		&WebhookError{StatusCode: 502}:   true,
+		errors.New("connection refused"): true,
+	}
+	for err, want := range cases {
+		if got := isRetryableWebhookErr(err); got != want {
+			t.Errorf("isRetryableWebhookErr(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestGetBackoff(t *testing.T) {
+	base, max := 2*time.Second, 30*time.Second
+	for attempts, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 4: 16 * time.Second, 10: max} {
+		got := getBackoff(attempts, base, max)
+		if got < want/2 || got > want {
+			t.Errorf("getBackoff(%d) = %v, want within [%v, %v]", attempts, got, want/2, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"fmt"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/policy"
+	"github.com/devtron-labs/source-controller/registry"
+	"gopkg.in/yaml.v2"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// envReferenceRegex matches the ${VAR} references interpolated in the config file, the
+// $VAR form is left alone as it is common in regexes and tag extract templates.
+var envReferenceRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LoadDeployConfigFile reads the sources of a yaml or json config file, in the format
+// of DEPLOY_CONFIG_EXTERNAL_CI, interpolating the env vars it references and validating them.
+func LoadDeployConfigFile(path string) ([]bean.DeployConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	deployConfigs, err := ParseDeployConfigs(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return deployConfigs, nil
+}
+
+// ParseDeployConfigs unmarshals the sources of the data, rejecting unknown keys,
+// interpolates the ${VAR} references of their string fields and validates them.
+func ParseDeployConfigs(data []byte) ([]bean.DeployConfig, error) {
+	var deployConfigs []bean.DeployConfig
+	err := yaml.UnmarshalStrict(data, &deployConfigs)
+	if err != nil {
+		return nil, err
+	}
+	err = interpolateEnv(deployConfigs)
+	if err != nil {
+		return nil, err
+	}
+	return deployConfigs, ValidateDeployConfigs(deployConfigs)
+}
+
+// ParseRequestedDeployConfigs unmarshals and validates the sources given by an api request,
+// rejecting unknown keys like ParseDeployConfigs. A request is not trusted with the env, the
+// files and the credentials of the controller: the ${VAR} references are left as is, and a
+// source can neither read a mounted docker config.json nor fall back to the default aws
+// credentials for ECR.
+func ParseRequestedDeployConfigs(data []byte) ([]bean.DeployConfig, error) {
+	var deployConfigs []bean.DeployConfig
+	err := yaml.UnmarshalStrict(data, &deployConfigs)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateDeployConfigs(deployConfigs)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for i, deployConfig := range deployConfigs {
+		entry := fmt.Sprintf("source #%d (EXTERNAL_CI_ID %d, REPO_NAME_EXTERNAL_CI %q)", i+1, deployConfig.ExternalCiId, deployConfig.RepoName)
+		if deployConfig.DockerConfigJsonPath != "" {
+			errs = append(errs, fmt.Sprintf("%s: DOCKER_CONFIG_JSON_PATH cannot be requested, use DOCKER_CONFIG_JSON", entry))
+		}
+		if deployConfig.RegistryType == registry.REGISTRYTYPE_ECR && deployConfig.AwsAccessKeyId == "" {
+			errs = append(errs, fmt.Sprintf("%s: AWS_ACCESS_KEY_ID is required", entry))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid deploy config:\n%s", strings.Join(errs, "\n"))
+	}
+	return deployConfigs, nil
+}
+
+// interpolateEnv replaces the ${VAR} references of the string fields of the sources with
+// the value of the env var, every reference to an unset env var is reported. The values are
+// substituted once the yaml is parsed, a secret holding yaml syntax is thus taken as is.
+func interpolateEnv(deployConfigs []bean.DeployConfig) error {
+	var missing []string
+	interpolate := func(value string) string {
+		return envReferenceRegex.ReplaceAllStringFunc(value, func(reference string) string {
+			name := envReferenceRegex.FindStringSubmatch(reference)[1]
+			envValue, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return envValue
+		})
+	}
+	for i := range deployConfigs {
+		interpolateStrings(reflect.ValueOf(&deployConfigs[i]).Elem(), interpolate)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("env vars referenced but not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// interpolateStrings sets the string fields of the struct, embedded structs included, to
+// their interpolated value.
+func interpolateStrings(v reflect.Value, interpolate func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(interpolate(v.String()))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				interpolateStrings(v.Field(i), interpolate)
+			}
+		}
+	}
+}
+
+// ValidateDeployConfigs checks every source, the error names the entry and the field at fault.
+func ValidateDeployConfigs(deployConfigs []bean.DeployConfig) error {
+	var errs []string
+	sourceKeys := make(map[string]int, len(deployConfigs))
+	for i, deployConfig := range deployConfigs {
+		entry := fmt.Sprintf("source #%d (EXTERNAL_CI_ID %d, REPO_NAME_EXTERNAL_CI %q)", i+1, deployConfig.ExternalCiId, deployConfig.RepoName)
+		for _, err := range validateDeployConfig(deployConfig) {
+			errs = append(errs, fmt.Sprintf("%s: %s", entry, err))
+		}
+		sourceKey := deployConfig.GetSourceKey()
+		if first, ok := sourceKeys[sourceKey]; ok {
+			errs = append(errs, fmt.Sprintf("%s: duplicate of source #%d", entry, first))
+			continue
+		}
+		sourceKeys[sourceKey] = i + 1
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid deploy config:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// ValidateDeployConfig checks a single source, the error names the fields at fault.
+func ValidateDeployConfig(deployConfig bean.DeployConfig) error {
+	if errs := validateDeployConfig(deployConfig); len(errs) > 0 {
+		return fmt.Errorf("invalid deploy config: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func validateDeployConfig(deployConfig bean.DeployConfig) []string {
+	var errs []string
+	if deployConfig.ExternalCiId <= 0 {
+		errs = append(errs, "EXTERNAL_CI_ID must be a positive pipeline id")
+	}
+	if deployConfig.RepoName == "" {
+		errs = append(errs, "REPO_NAME_EXTERNAL_CI is required")
+	}
+	switch deployConfig.RegistryType {
+	case "", registry.REGISTRYTYPE_ECR, registry.REGISTRYTYPE_GCR, registry.REGISTRYTYPE_ARTIFACT_REGISTRY,
+		registry.REGISTRYTYPE_DOCKER_HUB, registry.REGISTRYTYPE_OTHER:
+	default:
+		errs = append(errs, fmt.Sprintf("REGISTRY_TYPE %q is not one of ecr, gcr, artifact-registry, docker-hub, other", deployConfig.RegistryType))
+	}
+	if deployConfig.RegistryURL == "" && deployConfig.RegistryType != registry.REGISTRYTYPE_ECR {
+		errs = append(errs, "REGISTRY_URL_EXTERNAL_CI is required")
+	}
+	if deployConfig.AwsAccessKeyId != "" && deployConfig.AwsSecretAccessKey == "" {
+		errs = append(errs, "AWS_SECRET_ACCESS_KEY is required with AWS_ACCESS_KEY_ID")
+	}
+	if (deployConfig.Username == "") != (deployConfig.Password == "") {
+		errs = append(errs, "REGISTRY_USERNAME and REGISTRY_PASSWORD must be set together")
+	}
+	tagPolicy := deployConfig.TagPolicy
+	if _, err := policy.NewPolicer(bean.TagPolicy{TagOrder: tagPolicy.TagOrder}, nil); err != nil {
+		errs = append(errs, fmt.Sprintf("TAG_ORDER: %s", err))
+	}
+	if tagPolicy.SemverRange != "" {
+		if _, err := policy.NewSemVer(tagPolicy.SemverRange, tagPolicy.SemverIncludePrerelease); err != nil {
+			errs = append(errs, fmt.Sprintf("SEMVER_RANGE: %s", err))
+		}
+	}
+	if _, err := regexp.Compile(tagPolicy.TagIncludeRegex); err != nil {
+		errs = append(errs, fmt.Sprintf("TAG_INCLUDE_REGEX: %s", err))
+	}
+	if _, err := regexp.Compile(tagPolicy.TagExcludeRegex); err != nil {
+		errs = append(errs, fmt.Sprintf("TAG_EXCLUDE_REGEX: %s", err))
+	}
+	if tagPolicy.TagExtract != "" && tagPolicy.TagIncludeRegex == "" {
+		errs = append(errs, "TAG_EXTRACT requires TAG_INCLUDE_REGEX")
+	}
+	schedule := deployConfig.Schedule
+	for _, duration := range []struct{ field, value string }{
+		{"INTERVAL", schedule.Interval},
+		{"TIMEOUT", schedule.Timeout},
+		{"JITTER", schedule.Jitter},
+	} {
+		if duration.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(duration.value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", duration.field, err))
+		} else if d < 0 || (d == 0 && duration.field != "JITTER") {
+			errs = append(errs, fmt.Sprintf("%s: %q must be positive", duration.field, duration.value))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDeployConfigs(t *testing.T) {
+	t.Setenv("TEST_REGISTRY_PASSWORD", "s3cret")
+	data := `
+- EXTERNAL_CI_ID: 1
+  REPO_NAME_EXTERNAL_CI: devtron/app
+  REGISTRY_URL_EXTERNAL_CI: docker.io
+  REGISTRY_USERNAME: devtron
+  REGISTRY_PASSWORD: ${TEST_REGISTRY_PASSWORD}
+  TAG_INCLUDE_REGEX: '^main-(?P<ts>\d+)$'
+  TAG_EXTRACT: $ts
+  TAG_ORDER: numeric
+`
+	deployConfigs, err := ParseDeployConfigs([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deployConfigs) != 1 || deployConfigs[0].Password != "s3cret" || deployConfigs[0].TagExtract != "$ts" {
+		t.Errorf("unexpected deploy configs %+v", deployConfigs)
+	}
+}
+
+func TestParseDeployConfigs_SecretWithYamlSyntax(t *testing.T) {
+	secret := "p@ss: #1 \"quoted\"\nREGISTRY_URL_EXTERNAL_CI: evil.example.com"
+	t.Setenv("TEST_REGISTRY_PASSWORD", secret)
+	data := `
+- EXTERNAL_CI_ID: 1
+  REPO_NAME_EXTERNAL_CI: devtron/app
+  REGISTRY_URL_EXTERNAL_CI: docker.io
+  REGISTRY_USERNAME: devtron
+  REGISTRY_PASSWORD: ${TEST_REGISTRY_PASSWORD}
+`
+	deployConfigs, err := ParseDeployConfigs([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deployConfigs[0].Password != secret || deployConfigs[0].RegistryURL != "docker.io" {
+		t.Errorf("the secret must be taken as is, got password %q and registry %q", deployConfigs[0].Password, deployConfigs[0].RegistryURL)
+	}
+}
+
+func TestParseDeployConfigs_Invalid(t *testing.T) {
+	cases := map[string]struct {
+		data string
+		want []string
+	}{
+		"unset env var": {
+			data: "- EXTERNAL_CI_ID: 1\n  REGISTRY_PASSWORD: ${TEST_UNSET_PASSWORD}\n",
+			want: []string{"TEST_UNSET_PASSWORD"},
+		},
+		"unknown key": {
+			data: "- EXTERNAL_CI_ID: 1\n  REPO_NAME: app\n",
+			want: []string{"REPO_NAME"},
+		},
+		"invalid fields": {
+			data: "- EXTERNAL_CI_ID: 1\n  REPO_NAME_EXTERNAL_CI: app\n  REGISTRY_URL_EXTERNAL_CI: docker.io\n" +
+				"- EXTERNAL_CI_ID: 2\n  REPO_NAME_EXTERNAL_CI: other\n  TAG_ORDER: newest\n  TAG_INCLUDE_REGEX: '('\n",
+			want: []string{"source #2 (EXTERNAL_CI_ID 2", "REGISTRY_URL_EXTERNAL_CI is required", "TAG_ORDER", "TAG_INCLUDE_REGEX"},
+		},
+		"duplicate": {
+			data: "- {EXTERNAL_CI_ID: 1, REPO_NAME_EXTERNAL_CI: app, REGISTRY_URL_EXTERNAL_CI: docker.io}\n" +
+				"- {EXTERNAL_CI_ID: 1, REPO_NAME_EXTERNAL_CI: app, REGISTRY_URL_EXTERNAL_CI: docker.io}\n",
+			want: []string{"source #2", "duplicate of source #1"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseDeployConfigs([]byte(c.data))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range c.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRequestedDeployConfigs(t *testing.T) {
+	t.Setenv("TEST_REGISTRY_PASSWORD", "s3cret")
+	deployConfigs, err := ParseRequestedDeployConfigs([]byte("- {EXTERNAL_CI_ID: 1, REPO_NAME_EXTERNAL_CI: app, REGISTRY_URL_EXTERNAL_CI: docker.io," +
+		" REGISTRY_USERNAME: devtron, REGISTRY_PASSWORD: '${TEST_REGISTRY_PASSWORD}'}\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deployConfigs[0].Password != "${TEST_REGISTRY_PASSWORD}" {
+		t.Errorf("the env of the controller must not be interpolated in a request, got password %q", deployConfigs[0].Password)
+	}
+	_, err = ParseRequestedDeployConfigs([]byte("- {EXTERNAL_CI_ID: 1, REPO_NAME_EXTERNAL_CI: app, REGISTRY_URL_EXTERNAL_CI: docker.io," +
+		" DOCKER_CONFIG_JSON_PATH: /etc/shadow}\n" +
+		"- {EXTERNAL_CI_ID: 2, REPO_NAME_EXTERNAL_CI: app, REGISTRY_TYPE: ecr}\n"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"source #1", "DOCKER_CONFIG_JSON_PATH", "source #2", "AWS_ACCESS_KEY_ID"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"bytes"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DeployConfigWatcher calls reload whenever the content of the config file changes,
+// checking it every interval, and whenever the process receives a SIGHUP. The file is
+// only reloaded on SIGHUP when the interval is not positive.
+type DeployConfigWatcher struct {
+	logger   *zap.SugaredLogger
+	path     string
+	interval time.Duration
+	reload   func() error
+	lastData []byte
+}
+
+func NewDeployConfigWatcher(logger *zap.SugaredLogger, path string, interval time.Duration, reload func() error) *DeployConfigWatcher {
+	return &DeployConfigWatcher{
+		logger:   logger,
+		path:     path,
+		interval: interval,
+		reload:   reload,
+	}
+}
+
+// Start watches the file until the process exits. The file is compared by content as
+// mounted config maps are updated by swapping a symlink, leaving the mtime unreliable.
+func (w *DeployConfigWatcher) Start() {
+	w.lastData, _ = os.ReadFile(w.path)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	// a nil channel never receives, the file is then only reloaded on SIGHUP
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		tick = time.NewTicker(w.interval).C
+	} else {
+		w.logger.Infow("deploy config file not polled, reloading it on SIGHUP only", "path", w.path, "interval", w.interval)
+	}
+	go func() {
+		for {
+			select {
+			case <-hangup:
+				w.logger.Infow("SIGHUP received, reloading deploy config file", "path", w.path)
+				w.lastData, _ = os.ReadFile(w.path)
+				w.doReload()
+			case <-tick:
+				data, err := os.ReadFile(w.path)
+				if err != nil {
+					w.logger.Errorw("error in reading deploy config file", "err", err, "path", w.path)
+					continue
+				}
+				if bytes.Equal(data, w.lastData) {
+					continue
+				}
+				w.lastData = data
+				w.logger.Infow("deploy config file changed, reloading", "path", w.path)
+				w.doReload()
+			}
+		}
+	}()
+}
+
+func (w *DeployConfigWatcher) doReload() {
+	err := w.reload()
+	if err != nil {
+		w.logger.Errorw("error in reloading deploy config file, keeping the previous sources", "err", err, "path", w.path)
+	}
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/oci"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+type OCIRepositoryControllerConfig struct {
+	Enabled bool `env:"OCI_REPOSITORY_CONTROLLER_ENABLED" envDefault:"false"`
+	// WatchNamespace restricts the controller to the OCIRepository objects of a namespace, all of them are watched when empty
+	WatchNamespace string `env:"OCI_REPOSITORY_WATCH_NAMESPACE" envDefault:""`
+}
+
+// StartOCIRepositoryController starts, when enabled, a controller-runtime manager
+// reconciling the OCIRepository objects of the cluster the process runs in.
+func StartOCIRepositoryController(logger *zap.SugaredLogger, sourceReconciler SourceReconciler) error {
+	cfg := &OCIRepositoryControllerConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing OCIRepository controller config", "err", err)
+		return err
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	ctrl.SetLogger(zapr.NewLogger(logger.Desugar()))
+	scheme := runtime.NewScheme()
+	if err = clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err = oci.AddToScheme(scheme); err != nil {
+		return err
+	}
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		logger.Errorw("error in getting kubernetes config", "err", err)
+		return err
+	}
+	options := ctrl.Options{
+		Scheme: scheme,
+		// the metrics of the controller are served by the router of the app
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	}
+	if cfg.WatchNamespace != "" {
+		options.Cache = cache.Options{DefaultNamespaces: map[string]cache.Config{cfg.WatchNamespace: {}}}
+	}
+	mgr, err := ctrl.NewManager(restConfig, options)
+	if err != nil {
+		logger.Errorw("error in creating controller manager", "err", err)
+		return err
+	}
+	reconciler := &OCIRepositoryReconciler{
+		Client:           mgr.GetClient(),
+		EventRecorder:    mgr.GetEventRecorderFor("source-controller"),
+		apiReader:        mgr.GetAPIReader(),
+		logger:           logger,
+		sourceReconciler: sourceReconciler,
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
+		logger.Errorw("error in setting up OCIRepository controller", "err", err)
+		return err
+	}
+	go func() {
+		if err := mgr.Start(context.Background()); err != nil {
+			logger.Errorw("error in running controller manager", "err", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/oci"
+	"github.com/devtron-labs/source-controller/registry"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kuberecorder "k8s.io/client-go/tools/record"
+	"regexp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"strings"
+	"time"
+)
+
+const (
+	// ReadyCondition indicates the last reconciliation of the OCIRepository succeeded.
+	ReadyCondition = "Ready"
+
+	// SucceededReason signals that the reconciliation of the OCIRepository succeeded.
+	SucceededReason = "Succeeded"
+
+	// InvalidSpecReason signals that the OCIRepository cannot be turned into a source.
+	InvalidSpecReason = "InvalidSpec"
+
+	// defaultTimeout is the timeout of the registry operations when the spec has none.
+	defaultTimeout = 60 * time.Second
+)
+
+// SourceReconciler reconciles a source, it is implemented by the
+// SourceControllerService of the main package. Only the replica owning a source reconciles it.
+type SourceReconciler interface {
+	ReconcileSourceExclusively(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error)
+	OwnsSource(sourceKey string) bool
+}
+
+// OCIRepositoryReconciler reconciles the OCIRepository objects: the repository of each
+// of them is watched like a configured source, at the interval of its spec, and the
+// outcome is written back to its status.
+type OCIRepositoryReconciler struct {
+	client.Client
+	kuberecorder.EventRecorder
+	// apiReader reads the secrets directly from the api server, so that they are not cached
+	apiReader        client.Reader
+	logger           *zap.SugaredLogger
+	sourceReconciler SourceReconciler
+}
+
+// +kubebuilder:rbac:groups=source.devtron.ai,resources=ocirepositories,verbs=get;list;watch
+// +kubebuilder:rbac:groups=source.devtron.ai,resources=ocirepositories/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *OCIRepositoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&oci.OCIRepository{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
+
+func (r *OCIRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	obj := &oci.OCIRepository{}
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if obj.Spec.Suspend {
+		r.logger.Infow("reconciliation of OCIRepository is suspended", "name", req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	patch := client.MergeFrom(obj.DeepCopy())
+	obj.Status.ObservedGeneration = obj.Generation
+
+	deployConfig, err := r.getDeployConfig(ctx, obj)
+	if err != nil {
+		r.logger.Errorw("error in getting source of OCIRepository", "err", err, "name", req.NamespacedName)
+		r.markNotReady(obj, InvalidSpecReason, err)
+		return ctrl.Result{RequeueAfter: obj.GetRequeueAfter()}, r.patchStatus(ctx, obj, patch)
+	}
+	// a replica not owning the source checks again at the interval in case it comes to own it
+	if !r.sourceReconciler.OwnsSource(deployConfig.GetSourceKey()) {
+		return ctrl.Result{RequeueAfter: obj.GetRequeueAfter()}, nil
+	}
+	timeout := defaultTimeout
+	if obj.Spec.Timeout != nil {
+		timeout = obj.Spec.Timeout.Duration
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	report, err := r.sourceReconciler.ReconcileSourceExclusively(timeoutCtx, deployConfig)
+	if err != nil {
+		r.logger.Errorw("error in reconciling OCIRepository", "err", err, "name", req.NamespacedName)
+		r.markNotReady(obj, oci.OCIPullFailedReason, err)
+		return ctrl.Result{RequeueAfter: obj.GetRequeueAfter()}, r.patchStatus(ctx, obj, patch)
+	}
+
+	message := fmt.Sprintf("%d tags matched, %d digests discovered, %d notified", report.TagCount, len(report.DiscoveredDigests), len(report.NotifiedDigests))
+	if artifact := getLatestArtifact(deployConfig, report); artifact != nil {
+		obj.Status.Artifact = artifact
+		obj.Status.URL = artifact.URL
+	}
+	apimeta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+		Type:               ReadyCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: obj.Generation,
+		Reason:             SucceededReason,
+		Message:            message,
+	})
+	if len(report.NotifiedDigests) > 0 {
+		r.Eventf(obj, corev1.EventTypeNormal, SucceededReason, message)
+	}
+	return ctrl.Result{RequeueAfter: obj.GetRequeueAfter()}, r.patchStatus(ctx, obj, patch)
+}
+
+func (r *OCIRepositoryReconciler) markNotReady(obj *oci.OCIRepository, reason string, err error) {
+	apimeta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+		Type:               ReadyCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: obj.Generation,
+		Reason:             reason,
+		Message:            err.Error(),
+	})
+	r.Eventf(obj, corev1.EventTypeWarning, reason, err.Error())
+}
+
+func (r *OCIRepositoryReconciler) patchStatus(ctx context.Context, obj *oci.OCIRepository, patch client.Patch) error {
+	err := r.Status().Patch(ctx, obj, patch)
+	if err != nil {
+		r.logger.Errorw("error in patching status of OCIRepository", "err", err, "namespace", obj.Namespace, "name", obj.Name)
+	}
+	return err
+}
+
+// getDeployConfig turns the spec of the OCIRepository into a source. The tag reference
+// restricts the source to that tag and the semver reference to the tags in range.
+func (r *OCIRepositoryReconciler) getDeployConfig(ctx context.Context, obj *oci.OCIRepository) (bean.DeployConfig, error) {
+	deployConfig := bean.DeployConfig{ExternalCiId: obj.Spec.ExternalCiId}
+	if !strings.HasPrefix(obj.Spec.URL, oci.OCIRepositoryPrefix) {
+		return deployConfig, fmt.Errorf("url %q must start with %s", obj.Spec.URL, oci.OCIRepositoryPrefix)
+	}
+	host, repoName, ok := strings.Cut(strings.TrimPrefix(obj.Spec.URL, oci.OCIRepositoryPrefix), "/")
+	if !ok || repoName == "" {
+		return deployConfig, fmt.Errorf("url %q has no repository", obj.Spec.URL)
+	}
+	deployConfig.RegistryURL = host
+	deployConfig.RepoName = repoName
+	if ref := obj.Spec.Reference; ref != nil {
+		switch {
+		case ref.Digest != "":
+			return deployConfig, fmt.Errorf("digest references are not supported, the repository is watched for new tags")
+		case ref.SemVer != "":
+			deployConfig.SemverRange = ref.SemVer
+		case ref.Tag != "":
+			deployConfig.TagIncludeRegex = "^" + regexp.QuoteMeta(ref.Tag) + "$"
+		}
+	}
+	if obj.Spec.Provider == oci.AmazonOCIProvider {
+		// <registry id>.dkr.ecr.<region>.amazonaws.com, authenticated with the default aws credential chain
+		hostParts := strings.Split(host, ".")
+		if len(hostParts) < 6 || hostParts[1] != "dkr" || hostParts[2] != "ecr" {
+			return deployConfig, fmt.Errorf("host %q is not an ECR registry", host)
+		}
+		deployConfig.RegistryType = registry.REGISTRYTYPE_ECR
+		deployConfig.AwsRegistryId = hostParts[0]
+		deployConfig.AwsRegion = hostParts[3]
+		return deployConfig, nil
+	}
+	if obj.Spec.SecretRef != nil {
+		secret := &corev1.Secret{}
+		err := r.apiReader.Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: obj.Spec.SecretRef.Name}, secret)
+		if err != nil {
+			return deployConfig, fmt.Errorf("failed to get secret %q: %w", obj.Spec.SecretRef.Name, err)
+		}
+		dockerConfigJson, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			return deployConfig, fmt.Errorf("secret %q has no %s key", obj.Spec.SecretRef.Name, corev1.DockerConfigJsonKey)
+		}
+		deployConfig.DockerConfigJson = base64.StdEncoding.EncodeToString(dockerConfigJson)
+	}
+	return deployConfig, nil
+}
+
+// getLatestArtifact returns the newest tag selected by the reconciliation as an artifact.
+func getLatestArtifact(deployConfig bean.DeployConfig, report *bean.ReconcileReport) *bean.Artifact {
+	if len(report.Tags) == 0 {
+		return nil
+	}
+	tag := report.Tags[0]
+	for digest, digestTag := range report.DiscoveredDigests {
+		if digestTag != tag {
+			continue
+		}
+		return &bean.Artifact{
+			URL:      fmt.Sprintf("%s%s/%s:%s", oci.OCIRepositoryPrefix, deployConfig.RegistryURL, deployConfig.RepoName, tag),
+			Revision: fmt.Sprintf("%s@%s", tag, digest),
+			Digest:   digest,
+		}
+	}
+	return nil
+}
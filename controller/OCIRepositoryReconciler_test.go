@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/oci"
+	"github.com/devtron-labs/source-controller/registry"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+	"time"
+)
+
+// stubSourceReconciler records the sources it reconciles and answers with its report.
+type stubSourceReconciler struct {
+	notOwner      bool
+	report        *bean.ReconcileReport
+	err           error
+	deployConfigs []bean.DeployConfig
+}
+
+func (s *stubSourceReconciler) ReconcileSourceExclusively(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error) {
+	s.deployConfigs = append(s.deployConfigs, deployConfig)
+	return s.report, s.err
+}
+
+func (s *stubSourceReconciler) OwnsSource(sourceKey string) bool {
+	return !s.notOwner
+}
+
+const dockerConfigJson = `{"auths":{"registry.example.com":{"auth":"ZGV2dHJvbjpzM2NyZXQ="}}}`
+
+func newOCIRepository(spec oci.OCIRepositorySpec) *oci.OCIRepository {
+	spec.ExternalCiId = 7
+	spec.Interval = metav1.Duration{Duration: 5 * time.Minute}
+	return &oci.OCIRepository{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "devtron", Name: "app", Generation: 2},
+		Spec:       spec,
+	}
+}
+
+func newTestReconciler(t *testing.T, sourceReconciler SourceReconciler, obj *oci.OCIRepository) *OCIRepositoryReconciler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := oci.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "devtron", Name: "registry-credentials"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(dockerConfigJson)},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(obj, secret).
+		WithStatusSubresource(&oci.OCIRepository{}).
+		Build()
+	return &OCIRepositoryReconciler{
+		Client:           kubeClient,
+		EventRecorder:    record.NewFakeRecorder(10),
+		apiReader:        kubeClient,
+		logger:           zap.NewNop().Sugar(),
+		sourceReconciler: sourceReconciler,
+	}
+}
+
+func reconcile(t *testing.T, reconciler *OCIRepositoryReconciler) (ctrl.Result, *oci.OCIRepository) {
+	key := types.NamespacedName{Namespace: "devtron", Name: "app"}
+	result, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	obj := &oci.OCIRepository{}
+	if err = reconciler.Get(context.Background(), key, obj); err != nil {
+		t.Fatal(err)
+	}
+	return result, obj
+}
+
+func TestReconcile(t *testing.T) {
+	report := &bean.ReconcileReport{
+		TagCount:          2,
+		Tags:              []string{"1.1.0", "1.0.0"},
+		DiscoveredDigests: map[string]string{"sha256:b": "1.1.0", "sha256:a": "1.0.0"},
+		NotifiedDigests:   []string{"sha256:b"},
+	}
+	sourceReconciler := &stubSourceReconciler{report: report}
+	reconciler := newTestReconciler(t, sourceReconciler, newOCIRepository(oci.OCIRepositorySpec{
+		URL:       "oci://registry.example.com/team/app",
+		Reference: &oci.OCIRepositoryRef{SemVer: ">=1.0.0"},
+		SecretRef: &corev1.LocalObjectReference{Name: "registry-credentials"},
+	}))
+	result, obj := reconcile(t, reconciler)
+
+	if result.RequeueAfter != 5*time.Minute {
+		t.Errorf("Reconcile() requeues after %s, want the interval of the spec", result.RequeueAfter)
+	}
+	if len(sourceReconciler.deployConfigs) != 1 {
+		t.Fatalf("Reconcile() reconciled %d sources, want 1", len(sourceReconciler.deployConfigs))
+	}
+	deployConfig := sourceReconciler.deployConfigs[0]
+	if deployConfig.ExternalCiId != 7 || deployConfig.RegistryURL != "registry.example.com" || deployConfig.RepoName != "team/app" || deployConfig.SemverRange != ">=1.0.0" {
+		t.Errorf("Reconcile() source = %+v, want the url and the reference of the spec", deployConfig)
+	}
+	if deployConfig.DockerConfigJson != base64.StdEncoding.EncodeToString([]byte(dockerConfigJson)) {
+		t.Errorf("Reconcile() docker config json = %q, want the one of the secret", deployConfig.DockerConfigJson)
+	}
+	ready := apimeta.FindStatusCondition(obj.Status.Conditions, ReadyCondition)
+	if ready == nil || ready.Status != metav1.ConditionTrue || ready.Reason != SucceededReason || ready.ObservedGeneration != 2 {
+		t.Errorf("Reconcile() ready condition = %+v, want succeeded", ready)
+	}
+	if obj.Status.ObservedGeneration != 2 || obj.Status.Artifact == nil || obj.Status.Artifact.Revision != "1.1.0@sha256:b" ||
+		obj.Status.URL != "oci://registry.example.com/team/app:1.1.0" {
+		t.Errorf("Reconcile() status = %+v, want the newest tag as artifact", obj.Status)
+	}
+}
+
+func TestReconcile_NotReady(t *testing.T) {
+	tests := []struct {
+		name             string
+		spec             oci.OCIRepositorySpec
+		sourceReconciler *stubSourceReconciler
+		wantReason       string
+	}{
+		{
+			name:             "digest reference",
+			spec:             oci.OCIRepositorySpec{URL: "oci://registry.example.com/team/app", Reference: &oci.OCIRepositoryRef{Digest: "sha256:a"}},
+			sourceReconciler: &stubSourceReconciler{},
+			wantReason:       InvalidSpecReason,
+		},
+		{
+			name:             "missing secret",
+			spec:             oci.OCIRepositorySpec{URL: "oci://registry.example.com/team/app", SecretRef: &corev1.LocalObjectReference{Name: "missing"}},
+			sourceReconciler: &stubSourceReconciler{},
+			wantReason:       InvalidSpecReason,
+		},
+		{
+			name:             "registry error",
+			spec:             oci.OCIRepositorySpec{URL: "oci://registry.example.com/team/app"},
+			sourceReconciler: &stubSourceReconciler{err: errors.New("manifest unknown")},
+			wantReason:       oci.OCIPullFailedReason,
+		},
+	}
+	for _, tt := range tests {
+		result, obj := reconcile(t, newTestReconciler(t, tt.sourceReconciler, newOCIRepository(tt.spec)))
+		ready := apimeta.FindStatusCondition(obj.Status.Conditions, ReadyCondition)
+		if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != tt.wantReason {
+			t.Errorf("%s: Reconcile() ready condition = %+v, want %s", tt.name, ready, tt.wantReason)
+		}
+		if result.RequeueAfter != 5*time.Minute {
+			t.Errorf("%s: Reconcile() requeues after %s, want the interval of the spec", tt.name, result.RequeueAfter)
+		}
+	}
+}
+
+func TestReconcile_NotOwner(t *testing.T) {
+	sourceReconciler := &stubSourceReconciler{notOwner: true}
+	result, obj := reconcile(t, newTestReconciler(t, sourceReconciler, newOCIRepository(oci.OCIRepositorySpec{URL: "oci://registry.example.com/team/app"})))
+	if len(sourceReconciler.deployConfigs) != 0 || len(obj.Status.Conditions) != 0 {
+		t.Errorf("Reconcile() reconciled a source owned by another replica, status %+v", obj.Status)
+	}
+	if result.RequeueAfter != 5*time.Minute {
+		t.Errorf("Reconcile() requeues after %s, want the interval of the spec", result.RequeueAfter)
+	}
+}
+
+func TestGetDeployConfig_Ecr(t *testing.T) {
+	reconciler := &OCIRepositoryReconciler{}
+	deployConfig, err := reconciler.getDeployConfig(context.Background(), newOCIRepository(oci.OCIRepositorySpec{
+		URL:       "oci://445808685819.dkr.ecr.us-east-2.amazonaws.com/devtron/app",
+		Provider:  oci.AmazonOCIProvider,
+		Reference: &oci.OCIRepositoryRef{Tag: "v1.0"},
+	}))
+	if err != nil {
+		t.Fatalf("getDeployConfig() error = %v", err)
+	}
+	if deployConfig.RegistryType != registry.REGISTRYTYPE_ECR || deployConfig.AwsRegistryId != "445808685819" || deployConfig.AwsRegion != "us-east-2" ||
+		deployConfig.TagIncludeRegex != `^v1\.0$` {
+		t.Errorf("getDeployConfig() = %+v, want the ecr registry of the host restricted to the tag", deployConfig)
+	}
+	_, err = reconciler.getDeployConfig(context.Background(), newOCIRepository(oci.OCIRepositorySpec{
+		URL:      "oci://registry.example.com/devtron/app",
+		Provider: oci.AmazonOCIProvider,
+	}))
+	if err == nil {
+		t.Error("getDeployConfig() accepted a host which is not an ECR registry")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// createdTimeCacheSize bounds the number of images whose creation time is kept.
+const createdTimeCacheSize = 10000
+
+// createdTimeCache holds the creation time of the images by digest, which never changes, so
+// that an image is inspected once for the time based orderings rather than on every
+// reconciliation. It is cleared once it holds maxEntries images, bounding its memory.
+type createdTimeCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	times      map[string]time.Time
+}
+
+func newCreatedTimeCache(maxEntries int) *createdTimeCache {
+	return &createdTimeCache{maxEntries: maxEntries, times: make(map[string]time.Time)}
+}
+
+// createdTimeKey identifies the image of the repository url, the annotation and the config
+// of an image being two distinct times.
+func createdTimeKey(url, digest string, annotation bool) string {
+	return fmt.Sprintf("%s@%s/%t", url, digest, annotation)
+}
+
+func (c *createdTimeCache) get(key string) (time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	createdTime, ok := c.times[key]
+	return createdTime, ok
+}
+
+func (c *createdTimeCache) add(key string, createdTime time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.times) >= c.maxEntries {
+		c.times = make(map[string]time.Time)
+	}
+	c.times[key] = createdTime
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_createdTimeCache(t *testing.T) {
+	createdOn := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	cache := newCreatedTimeCache(2)
+	configKey := createdTimeKey("docker.io/devtron/app", "sha256:a", false)
+	cache.add(configKey, createdOn)
+	if createdTime, ok := cache.get(configKey); !ok || !createdTime.Equal(createdOn) {
+		t.Errorf("get() = %s, %v, want the added time", createdTime, ok)
+	}
+	if _, ok := cache.get(createdTimeKey("docker.io/devtron/app", "sha256:a", true)); ok {
+		t.Error("get() returned the config time for the annotation time")
+	}
+	cache.add(createdTimeKey("docker.io/devtron/app", "sha256:b", false), createdOn)
+	cache.add(createdTimeKey("docker.io/devtron/app", "sha256:c", false), createdOn)
+	if _, ok := cache.get(configKey); ok || len(cache.times) != 1 {
+		t.Errorf("the cache holds %d images, want it cleared once full", len(cache.times))
+	}
+}
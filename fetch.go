@@ -50,8 +50,7 @@ func fetch() {
 		}
 		digest, err := crane.Digest(tagUrl, opts.craneOpts...)
 		if err != nil {
-			fmt.Errorf("error")
-
+			fmt.Println("error" + err.Error())
 		}
 		digestTagMap[digest] = tag
 		digests = append(digests, digest)
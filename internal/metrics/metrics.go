@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	ReasonThrottled = "throttled"
+	ReasonTimeout   = "timeout"
+	ReasonError     = "error"
+)
+
+var ReconcileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "source_controller_reconcile_duration_seconds",
+	Help:    "Duration of the reconciliations of a source, the count being the number of reconciliations.",
+	Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+}, []string{"source", "registry_host", "result"})
+
+var ReconcileErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "source_controller_reconcile_errors_total",
+	Help: "Failed reconciliations of a source by reason.",
+}, []string{"source", "registry_host", "reason"})
+
+var RegistryRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "source_controller_registry_requests_total",
+	Help: "Requests sent to the registries by operation and status code.",
+}, []string{"registry_host", "operation", "status_code"})
+
+var RegistryThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "source_controller_registry_throttled_total",
+	Help: "Times a registry host throttled us and was backed off.",
+}, []string{"registry_host"})
+
+var RegistryBackoffUntil = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "source_controller_registry_backoff_until_timestamp_seconds",
+	Help: "End of the backoff of a throttled registry host.",
+}, []string{"registry_host"})
+
+var TagsDiscovered = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "source_controller_tags_discovered",
+	Help: "Tags selected by the tag policy in the last reconciliation of a source.",
+}, []string{"source"})
+
+var ArtifactsNotified = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "source_controller_artifacts_notified_total",
+	Help: "Artifacts notified to the orchestrator by a reconciliation of a source.",
+}, []string{"source"})
+
+var WebhookDeliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "source_controller_webhook_delivery_duration_seconds",
+	Help:    "Latency of the calls to the external ci webhook.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"result"})
+
+var WebhookDeliveryFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "source_controller_webhook_delivery_failures_total",
+	Help: "Failed calls to the external ci webhook, by whether the image is retried (retry) or dead lettered (dead_letter).",
+}, []string{"reason"})
+
+var Leader = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "source_controller_leader",
+	Help: "1 when the replica is the leader reconciling the sources, 0 when standby.",
+})
+
+var ShardMembers = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "source_controller_shard_members",
+	Help: "Alive replicas the sources are sharded over, as seen by the replica.",
+})
+
+var OwnedSources = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "source_controller_owned_sources",
+	Help: "Sources owned, and reconciled, by the replica.",
+})
+
+var PushToTrigger = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "source_controller_push_to_trigger_seconds",
+	Help:    "Time from the push, or creation, of an image to the acceptance of its notification by the external ci webhook.",
+	Buckets: []float64{15, 30, 60, 120, 300, 600, 900, 1800, 3600, 7200, 21600, 86400},
+})
+
+var PushEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "source_controller_push_events_total",
+	Help: "Push events received from the registries, by receiver and whether they matched a source (matched), none (unmatched) or failed (error).",
+}, []string{"receiver", "result"})
+
+var lastSuccess = &lastSuccessCollector{
+	desc: prometheus.NewDesc("source_controller_seconds_since_last_successful_reconcile",
+		"Time since the last successful reconciliation of a source.", []string{"source"}, nil),
+	lastSuccessOn: make(map[string]time.Time),
+}
+
+// recordedSources are the sources having series in the source labelled metrics, so that the
+// series of the sources no longer configured can be deleted.
+var recordedSources = struct {
+	lock    sync.Mutex
+	sources map[string]bool
+}{sources: make(map[string]bool)}
+
+func init() {
+	prometheus.MustRegister(lastSuccess)
+}
+
+// lastSuccessCollector computes the time since the last success of every source when scraped.
+type lastSuccessCollector struct {
+	desc          *prometheus.Desc
+	lock          sync.Mutex
+	lastSuccessOn map[string]time.Time
+}
+
+func (c *lastSuccessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *lastSuccessCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	for source, lastSuccessOn := range c.lastSuccessOn {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(lastSuccessOn).Seconds(), source)
+	}
+}
+
+// RecordReconcile records the outcome of a reconciliation of a source, reason being empty when it succeeded.
+func RecordReconcile(source, registryHost string, duration time.Duration, reason string, tagCount, notifiedCount int) {
+	recordedSources.lock.Lock()
+	recordedSources.sources[source] = true
+	recordedSources.lock.Unlock()
+	result := ResultSuccess
+	if reason != "" {
+		result = ResultError
+		ReconcileErrors.WithLabelValues(source, registryHost, reason).Inc()
+	}
+	ReconcileDuration.WithLabelValues(source, registryHost, result).Observe(duration.Seconds())
+	TagsDiscovered.WithLabelValues(source).Set(float64(tagCount))
+	ArtifactsNotified.WithLabelValues(source).Add(float64(notifiedCount))
+	if reason == "" {
+		lastSuccess.lock.Lock()
+		lastSuccess.lastSuccessOn[source] = time.Now()
+		lastSuccess.lock.Unlock()
+	}
+}
+
+// ForgetSourcesExcept deletes the series of the sources no longer configured from every source
+// labelled metric, the time since their last success included.
+func ForgetSourcesExcept(sources []string) {
+	configured := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		configured[source] = true
+	}
+	recordedSources.lock.Lock()
+	defer recordedSources.lock.Unlock()
+	lastSuccess.lock.Lock()
+	defer lastSuccess.lock.Unlock()
+	for source := range recordedSources.sources {
+		if configured[source] {
+			continue
+		}
+		labels := prometheus.Labels{"source": source}
+		ReconcileDuration.DeletePartialMatch(labels)
+		ReconcileErrors.DeletePartialMatch(labels)
+		TagsDiscovered.DeleteLabelValues(source)
+		ArtifactsNotified.DeleteLabelValues(source)
+		delete(lastSuccess.lastSuccessOn, source)
+		delete(recordedSources.sources, source)
+	}
+}
+
+// RecordRegistryRequest counts a request sent to a registry, statusCode being 0 when no response was received.
+func RecordRegistryRequest(registryHost, operation string, statusCode int) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	RegistryRequests.WithLabelValues(registryHost, operation, status).Inc()
+}
+
+// RecordRegistryThrottled records the backoff of a registry host.
+func RecordRegistryThrottled(registryHost string, until time.Time) {
+	RegistryThrottled.WithLabelValues(registryHost).Inc()
+	RegistryBackoffUntil.WithLabelValues(registryHost).Set(float64(until.Unix()))
+}
+
+// RecordWebhookDelivery records a call to the external ci webhook, reason being empty when it succeeded.
+func RecordWebhookDelivery(duration time.Duration, reason string) {
+	result := ResultSuccess
+	if reason != "" {
+		result = ResultError
+		WebhookDeliveryFailures.WithLabelValues(reason).Inc()
+	}
+	WebhookDeliveryDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// RecordPushEvent counts a push event received, result being matched, unmatched or error.
+func RecordPushEvent(receiver, result string) {
+	PushEvents.WithLabelValues(receiver, result).Inc()
+}
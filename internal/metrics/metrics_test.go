@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+	"time"
+)
+
+func TestForgetSourcesExcept(t *testing.T) {
+	RecordReconcile("1/devtron/kept", "registry.example.com", time.Second, "", 2, 1)
+	RecordReconcile("2/devtron/removed", "registry.example.com", time.Second, ReasonThrottled, 0, 0)
+	RecordReconcile("2/devtron/removed", "registry.example.com", time.Second, "", 3, 1)
+
+	ForgetSourcesExcept([]string{"1/devtron/kept"})
+
+	removed := prometheus.Labels{"source": "2/devtron/removed"}
+	if n := ReconcileDuration.DeletePartialMatch(removed); n != 0 {
+		t.Errorf("ForgetSourcesExcept() left %d reconcile duration series of the removed source", n)
+	}
+	if n := ReconcileErrors.DeletePartialMatch(removed); n != 0 {
+		t.Errorf("ForgetSourcesExcept() left %d reconcile error series of the removed source", n)
+	}
+	if TagsDiscovered.DeleteLabelValues("2/devtron/removed") || ArtifactsNotified.DeleteLabelValues("2/devtron/removed") {
+		t.Error("ForgetSourcesExcept() left the discovered or notified series of the removed source")
+	}
+	if _, ok := lastSuccess.lastSuccessOn["2/devtron/removed"]; ok {
+		t.Error("ForgetSourcesExcept() left the last success of the removed source")
+	}
+	if n := ReconcileDuration.DeletePartialMatch(prometheus.Labels{"source": "1/devtron/kept"}); n != 1 {
+		t.Errorf("ForgetSourcesExcept() deleted the reconcile duration of a configured source, %d series left", n)
+	}
+	if !TagsDiscovered.DeleteLabelValues("1/devtron/kept") {
+		t.Error("ForgetSourcesExcept() deleted the discovered tags of a configured source")
+	}
+}
@@ -0,0 +1,61 @@
+package util
+
+import "reflect"
+
+const secretMask = "********"
+
+// ObfuscateSecretTags returns a copy of cfg, a struct, a pointer to a struct or a slice
+// of structs, with every non-empty string field tagged `secretData` replaced by a mask.
+// Nested structs, pointers to structs and slices of structs are walked as well so
+// that configs embedding registry credentials can be logged safely.
+func ObfuscateSecretTags(cfg interface{}) interface{} {
+	src := reflect.ValueOf(cfg)
+	if !src.IsValid() {
+		return cfg
+	}
+	dst := reflect.New(src.Type()).Elem()
+	dst.Set(src)
+	obfuscateValue(src, dst)
+	return dst.Interface()
+}
+
+func obfuscateStruct(src, dst reflect.Value) {
+	dst.Set(src)
+	t := src.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if _, ok := field.Tag.Lookup("secretData"); ok {
+			if field.Type.Kind() == reflect.String && src.Field(i).Len() > 0 {
+				dst.Field(i).SetString(secretMask)
+			}
+			continue
+		}
+		obfuscateValue(src.Field(i), dst.Field(i))
+	}
+}
+
+func obfuscateValue(src, dst reflect.Value) {
+	switch src.Kind() {
+	case reflect.Struct:
+		obfuscateStruct(src, dst)
+	case reflect.Ptr:
+		if src.IsNil() || src.Elem().Kind() != reflect.Struct {
+			return
+		}
+		elem := reflect.New(src.Elem().Type())
+		obfuscateStruct(src.Elem(), elem.Elem())
+		dst.Set(elem)
+	case reflect.Slice:
+		if src.IsNil() || src.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		items := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			obfuscateStruct(src.Index(i), items.Index(i))
+		}
+		dst.Set(items)
+	}
+}
@@ -0,0 +1,39 @@
+package util_test
+
+import (
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/internal/util"
+	"strings"
+	"testing"
+)
+
+func TestObfuscateSecretTags(t *testing.T) {
+	deployConfigs := []bean.DeployConfig{{
+		RepoName: "app",
+		RegistryCredential: bean.RegistryCredential{
+			Username: "user",
+			Password: "registry-password",
+			Token:    "registry-token",
+		},
+		EcrConfig: bean.EcrConfig{AwsSecretAccessKey: "aws-secret"},
+	}}
+	obfuscated, ok := util.ObfuscateSecretTags(deployConfigs).([]bean.DeployConfig)
+	if !ok || len(obfuscated) != 1 {
+		t.Fatalf("ObfuscateSecretTags() = %#v, want a []bean.DeployConfig of one source", obfuscated)
+	}
+	for _, secret := range []string{obfuscated[0].Password, obfuscated[0].Token, obfuscated[0].AwsSecretAccessKey} {
+		if strings.Contains(secret, "registry-") || strings.Contains(secret, "aws-") {
+			t.Errorf("ObfuscateSecretTags() left the secret %q", secret)
+		}
+	}
+	if obfuscated[0].Username != "user" || obfuscated[0].RepoName != "app" {
+		t.Errorf("ObfuscateSecretTags() = %+v, want the fields without secretData kept", obfuscated[0])
+	}
+	if deployConfigs[0].Password != "registry-password" {
+		t.Errorf("ObfuscateSecretTags() changed the given sources")
+	}
+	pointer, ok := util.ObfuscateSecretTags(&deployConfigs[0]).(*bean.DeployConfig)
+	if !ok || pointer.Password == "registry-password" || pointer == &deployConfigs[0] {
+		t.Errorf("ObfuscateSecretTags() of a pointer = %+v, want an obfuscated copy", pointer)
+	}
+}
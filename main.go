@@ -15,7 +15,7 @@ func main() {
 		log.Panic(err)
 	}
 	//     gracefulStop start
-	var gracefulStop = make(chan os.Signal)
+	var gracefulStop = make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
 	go func() {
@@ -0,0 +1,88 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// dockerConfigKeychain is an authn.Keychain backed by a single docker
+// config.json, resolving credentials the same way authn.DefaultKeychain does
+// for the config found in the home directory.
+type dockerConfigKeychain struct {
+	configFile *configfile.ConfigFile
+}
+
+// Resolve implements authn.Keychain.
+func (k dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	var cfg, empty types.AuthConfig
+	for _, key := range []string{target.String(), target.RegistryStr()} {
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+		var err error
+		cfg, err = k.configFile.GetAuthConfig(key)
+		if err != nil {
+			return nil, err
+		}
+		// GetAuthConfig always sets the server address, clear it for the empty check
+		cfg.ServerAddress = ""
+		if cfg != empty {
+			break
+		}
+	}
+	if cfg == empty {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
+
+// GetKeychainAndAuth builds the keychain and authenticator for a source out of its
+// registry credentials. The authenticator is only set for token and
+// username/password credentials, as it takes precedence over the keychain in
+// makeRemoteOptions; otherwise a docker config or the Anonymous keychain is returned.
+func GetKeychainAndAuth(cred bean.RegistryCredential) (authn.Keychain, authn.Authenticator, error) {
+	if cred.Token != "" {
+		return Anonymous{}, authn.FromConfig(authn.AuthConfig{RegistryToken: cred.Token}), nil
+	}
+	if cred.Username != "" || cred.Password != "" {
+		return Anonymous{}, &authn.Basic{Username: cred.Username, Password: cred.Password}, nil
+	}
+	var reader io.Reader
+	if cred.DockerConfigJson != "" {
+		data, err := base64.StdEncoding.DecodeString(cred.DockerConfigJson)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid base64 docker config json: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	} else if cred.DockerConfigJsonPath != "" {
+		file, err := os.Open(cred.DockerConfigJsonPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error in opening docker config json: %w", err)
+		}
+		defer file.Close()
+		reader = file
+	} else {
+		return Anonymous{}, nil, nil
+	}
+	configFile, err := config.LoadFromReader(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in parsing docker config json: %w", err)
+	}
+	return dockerConfigKeychain{configFile: configFile}, nil, nil
+}
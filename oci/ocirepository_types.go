@@ -20,6 +20,7 @@ import (
 	"github.com/devtron-labs/source-controller/bean"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -53,7 +54,14 @@ const (
 )
 
 // OCIRepositorySpec defines the desired state of OCIRepository
+// +kubebuilder:object:generate=true
 type OCIRepositorySpec struct {
+	// ExternalCiId is the id of the external ci pipeline of the orchestrator
+	// notified of the images discovered in the repository.
+	// +kubebuilder:validation:Minimum=1
+	// +required
+	ExternalCiId int `json:"externalCiId"`
+
 	// URL is a reference to an OCI artifact repository hosted
 	// on a remote container registry.
 	// +kubebuilder:validation:Pattern="^oci://.*$"
@@ -81,7 +89,7 @@ type OCIRepositorySpec struct {
 	// credentials to resolve image metadata.
 	// The secret must be of type kubernetes.io/dockerconfigjson.
 	// +optional
-	//SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
 
 	// Verify contains the secret name containing the trusted public keys
 	// used to verify the signature and specifies which provider to use to check
@@ -144,6 +152,7 @@ type OCIRepositorySpec struct {
 }
 
 // OCIRepositoryRef defines the image reference for the OCIRepository's URL
+// +kubebuilder:object:generate=true
 type OCIRepositoryRef struct {
 	// Digest is the image digest to pull, takes precedence over SemVer.
 	// The value should be in the format 'sha256:<HASH>'.
@@ -161,6 +170,7 @@ type OCIRepositoryRef struct {
 }
 
 // OCILayerSelector specifies which layer should be extracted from an OCI Artifact
+// +kubebuilder:object:generate=true
 type OCILayerSelector struct {
 	// MediaType specifies the OCI media type of the layer
 	// which should be extracted from the OCI Artifact. The
@@ -178,6 +188,7 @@ type OCILayerSelector struct {
 }
 
 // OCIRepositoryVerification verifies the authenticity of an OCI Artifact
+// +kubebuilder:object:generate=true
 type OCIRepositoryVerification struct {
 	// Provider specifies the technology used to sign the OCI Artifact.
 	// +kubebuilder:validation:Enum=cosign
@@ -191,6 +202,7 @@ type OCIRepositoryVerification struct {
 }
 
 // OCIRepositoryStatus defines the observed state of OCIRepository
+// +kubebuilder:object:generate=true
 type OCIRepositoryStatus struct {
 	// ObservedGeneration is the last observed generation.
 	// +optional
@@ -286,9 +298,11 @@ func (in *OCIRepository) GetLayerOperation() string {
 // +genclient
 // +genclient:Namespaced
 // +kubebuilder:storageversion
+// +kubebuilder:object:generate=true
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:shortName=ocirepo
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="External CI",type=integer,JSONPath=`.spec.externalCiId`
 // +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.spec.url`
 // +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description=""
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message",description=""
@@ -305,6 +319,7 @@ type OCIRepository struct {
 }
 
 // OCIRepositoryList contains a list of OCIRepository
+// +kubebuilder:object:generate=true
 // +kubebuilder:object:root=true
 type OCIRepositoryList struct {
 	metav1.TypeMeta `json:",inline"`
@@ -312,6 +327,6 @@ type OCIRepositoryList struct {
 	Items           []OCIRepository `json:"items"`
 }
 
-//func init() {
-//	SchemeBuilder.Register(&OCIRepository{}, &OCIRepositoryList{})
-//}
+func init() {
+	SchemeBuilder.Register(&OCIRepository{}, &OCIRepositoryList{})
+}
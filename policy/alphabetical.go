@@ -0,0 +1,13 @@
+package policy
+
+import "sort"
+
+// Alphabetical orders the tags in descending lexical order.
+type Alphabetical struct{}
+
+// Sort implements Policer.
+func (p Alphabetical) Sort(tags []string) []string {
+	sorted := append([]string(nil), tags...)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	return sorted
+}
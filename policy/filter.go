@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RegexFilter keeps the tags matching the include pattern and not matching the
+// exclude pattern. When an extract template is set, like "$buildnum", each kept
+// tag is mapped to the value expanded from the submatches of the include pattern.
+type RegexFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	extract string
+}
+
+// NewRegexFilter compiles the patterns of a RegexFilter, empty patterns are ignored.
+func NewRegexFilter(include, exclude, extract string) (*RegexFilter, error) {
+	f := &RegexFilter{extract: extract}
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("invalid tag include regex %q: %w", include, err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("invalid tag exclude regex %q: %w", exclude, err)
+		}
+	}
+	if extract != "" && f.include == nil {
+		return nil, fmt.Errorf("tag extract %q requires a tag include regex", extract)
+	}
+	return f, nil
+}
+
+// Apply returns the tags kept by the filter.
+func (f *RegexFilter) Apply(tags []string) []string {
+	filtered := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if f.include != nil && !f.include.MatchString(tag) {
+			continue
+		}
+		if f.exclude != nil && f.exclude.MatchString(tag) {
+			continue
+		}
+		filtered = append(filtered, tag)
+	}
+	return filtered
+}
+
+// HasExtract tells whether the tags are ordered by an extracted value.
+func (f *RegexFilter) HasExtract() bool {
+	return f.extract != ""
+}
+
+// Extract returns the value extracted from a tag kept by the filter.
+func (f *RegexFilter) Extract(tag string) string {
+	submatches := f.include.FindStringSubmatchIndex(tag)
+	if submatches == nil {
+		return ""
+	}
+	return string(f.include.ExpandString(nil, f.extract, tag, submatches))
+}
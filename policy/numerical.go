@@ -0,0 +1,28 @@
+package policy
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Numerical orders the tags by their numeric value, highest first. Tags which
+// are not numbers are dropped.
+type Numerical struct{}
+
+// Sort implements Policer.
+func (p Numerical) Sort(tags []string) []string {
+	values := make(map[string]float64, len(tags))
+	sorted := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		value, err := strconv.ParseFloat(tag, 64)
+		if err != nil {
+			continue
+		}
+		values[tag] = value
+		sorted = append(sorted, tag)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return values[sorted[i]] > values[sorted[j]]
+	})
+	return sorted
+}
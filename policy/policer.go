@@ -0,0 +1,46 @@
+package policy
+
+import (
+	"fmt"
+	"github.com/devtron-labs/source-controller/bean"
+	"time"
+)
+
+// Policer orders the tags of a repository from the newest to the oldest.
+// Tags which cannot be ordered by the policy are dropped.
+type Policer interface {
+	Sort(tags []string) []string
+}
+
+// NewPolicer returns the Policer for the given tag policy. The times are only
+// used by the time based orderings and map each tag to the time it was created
+// or pushed at.
+func NewPolicer(tagPolicy bean.TagPolicy, times map[string]time.Time) (Policer, error) {
+	tagOrder := tagPolicy.GetTagOrder()
+	switch tagOrder {
+	case bean.TagOrderRegistry:
+		return RegistryOrder{}, nil
+	case bean.TagOrderAlphabetical:
+		return Alphabetical{}, nil
+	case bean.TagOrderNumeric:
+		return Numerical{}, nil
+	case bean.TagOrderSemver:
+		return NewSemVer(tagPolicy.SemverRange, tagPolicy.SemverIncludePrerelease)
+	case bean.TagOrderCreated, bean.TagOrderAnnotation, bean.TagOrderPushTime:
+		return Time{Times: times}, nil
+	}
+	return nil, fmt.Errorf("unknown tag order %q", tagOrder)
+}
+
+// IsTimeBased tells whether the tag order needs the creation or push time of the tags.
+func IsTimeBased(tagOrder string) bool {
+	return tagOrder == bean.TagOrderCreated || tagOrder == bean.TagOrderAnnotation || tagOrder == bean.TagOrderPushTime
+}
+
+// RegistryOrder keeps the tags in the order returned by the registry.
+type RegistryOrder struct{}
+
+// Sort implements Policer.
+func (p RegistryOrder) Sort(tags []string) []string {
+	return tags
+}
@@ -0,0 +1,155 @@
+package policy
+
+import (
+	"github.com/devtron-labs/source-controller/bean"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicer_Sort(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		policy bean.TagPolicy
+		times  map[string]time.Time
+		tags   []string
+		want   []string
+	}{
+		{
+			name:   "registry order is kept",
+			policy: bean.TagPolicy{TagOrder: bean.TagOrderRegistry},
+			tags:   []string{"b", "a", "c"},
+			want:   []string{"b", "a", "c"},
+		},
+		{
+			name:   "alphabetical descending",
+			policy: bean.TagPolicy{TagOrder: bean.TagOrderAlphabetical},
+			tags:   []string{"b", "a", "c"},
+			want:   []string{"c", "b", "a"},
+		},
+		{
+			name:   "numeric drops non numbers",
+			policy: bean.TagPolicy{TagOrder: bean.TagOrderNumeric},
+			tags:   []string{"9", "latest", "10", "1.5"},
+			want:   []string{"10", "9", "1.5"},
+		},
+		{
+			name:   "semver drops invalid versions",
+			policy: bean.TagPolicy{TagOrder: bean.TagOrderSemver},
+			tags:   []string{"v1.2.0", "1.10.0", "main", "1.9.3", "2.0.0-rc.1"},
+			want:   []string{"2.0.0-rc.1", "1.10.0", "1.9.3", "v1.2.0"},
+		},
+		{
+			name:   "semver range without prereleases",
+			policy: bean.TagPolicy{SemverRange: ">=1.4.0 <2.0.0"},
+			tags:   []string{"1.3.9", "1.4.0", "v1.8.2", "1.9.0-rc.1", "2.0.0", "main"},
+			want:   []string{"v1.8.2", "1.4.0"},
+		},
+		{
+			name:   "semver range with prereleases",
+			policy: bean.TagPolicy{SemverRange: ">=1.4.0 <2.0.0", SemverIncludePrerelease: true},
+			tags:   []string{"1.3.9", "1.4.0", "v1.8.2", "1.9.0-rc.1", "2.0.0"},
+			want:   []string{"1.9.0-rc.1", "v1.8.2", "1.4.0"},
+		},
+		{
+			name:   "time newest first",
+			policy: bean.TagPolicy{TagOrder: bean.TagOrderCreated},
+			times:  map[string]time.Time{"old": now.Add(-time.Hour), "new": now, "older": now.Add(-2 * time.Hour)},
+			tags:   []string{"older", "new", "unknown", "old"},
+			want:   []string{"new", "old", "older"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policer, err := NewPolicer(tt.policy, tt.times)
+			if err != nil {
+				t.Fatalf("NewPolicer() error = %v", err)
+			}
+			if got := policer.Sort(tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPolicer_InvalidPolicy(t *testing.T) {
+	if _, err := NewPolicer(bean.TagPolicy{TagOrder: "newest"}, nil); err == nil {
+		t.Errorf("NewPolicer() expected error for unknown tag order")
+	}
+	if _, err := NewPolicer(bean.TagPolicy{SemverRange: ">=1.x.y"}, nil); err == nil {
+		t.Errorf("NewPolicer() expected error for invalid semver range")
+	}
+	if _, err := NewTagSelector(bean.TagPolicy{TagExtract: "$buildnum"}); err == nil {
+		t.Errorf("NewTagSelector() expected error for extract without include regex")
+	}
+}
+
+func TestTagSelector(t *testing.T) {
+	tags := []string{"main-abc123-9", "main-def456-10", "pr-12-abc123", "cache", "main-0a1b2c-2"}
+	tests := []struct {
+		name   string
+		policy bean.TagPolicy
+		want   []string
+	}{
+		{
+			name:   "include and exclude",
+			policy: bean.TagPolicy{TagIncludeRegex: "^(main|pr)-", TagExcludeRegex: "^pr-", TagOrder: bean.TagOrderAlphabetical},
+			want:   []string{"main-def456-10", "main-abc123-9", "main-0a1b2c-2"},
+		},
+		{
+			name:   "numeric extract",
+			policy: bean.TagPolicy{TagIncludeRegex: `^main-[a-f0-9]+-(?P<buildnum>\d+)$`, TagExtract: "$buildnum", TagOrder: bean.TagOrderNumeric},
+			want:   []string{"main-def456-10", "main-abc123-9", "main-0a1b2c-2"},
+		},
+		{
+			name:   "alphabetical extract",
+			policy: bean.TagPolicy{TagIncludeRegex: `^main-(?P<sha>[a-f0-9]+)-\d+$`, TagExtract: "$sha", TagOrder: bean.TagOrderAlphabetical},
+			want:   []string{"main-def456-10", "main-abc123-9", "main-0a1b2c-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := NewTagSelector(tt.policy)
+			if err != nil {
+				t.Fatalf("NewTagSelector() error = %v", err)
+			}
+			got, err := selector.Sort(selector.Filter(tags), nil)
+			if err != nil {
+				t.Fatalf("Sort() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagSelector_Matches(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy bean.TagPolicy
+		tag    string
+		want   bool
+	}{
+		{"in semver range", bean.TagPolicy{SemverRange: ">=1.0.0 <2.0.0"}, "1.4.2", true},
+		{"out of semver range", bean.TagPolicy{SemverRange: ">=1.0.0 <2.0.0"}, "2.0.0", false},
+		{"prerelease excluded", bean.TagPolicy{SemverRange: ">=1.0.0 <2.0.0"}, "1.1.0-rc1", false},
+		{"not a version", bean.TagPolicy{SemverRange: ">=1.0.0"}, "latest", false},
+		{"excluded by regex", bean.TagPolicy{TagExcludeRegex: "^pr-", TagOrder: bean.TagOrderAlphabetical}, "pr-12", false},
+		{"numeric extract", bean.TagPolicy{TagIncludeRegex: `^main-(?P<buildnum>\w+)$`, TagExtract: "$buildnum", TagOrder: bean.TagOrderNumeric}, "main-12", true},
+		{"not numeric extract", bean.TagPolicy{TagIncludeRegex: `^main-(?P<buildnum>\w+)$`, TagExtract: "$buildnum", TagOrder: bean.TagOrderNumeric}, "main-abc", false},
+		{"time based", bean.TagPolicy{TagOrder: bean.TagOrderPushTime}, "latest", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := NewTagSelector(tt.policy)
+			if err != nil {
+				t.Fatalf("NewTagSelector() error = %v", err)
+			}
+			if got := selector.Matches(tt.tag); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"github.com/devtron-labs/source-controller/bean"
+	"time"
+)
+
+// TagSelector applies the tag policy of a source: the regex filter first, then
+// the ordering, either on the tags themselves or on the values extracted from them.
+type TagSelector struct {
+	tagPolicy bean.TagPolicy
+	filter    *RegexFilter
+}
+
+// NewTagSelector validates the tag policy and returns its TagSelector.
+func NewTagSelector(tagPolicy bean.TagPolicy) (*TagSelector, error) {
+	filter, err := NewRegexFilter(tagPolicy.TagIncludeRegex, tagPolicy.TagExcludeRegex, tagPolicy.TagExtract)
+	if err != nil {
+		return nil, err
+	}
+	// build the policer once to validate the order and the semver range
+	if _, err = NewPolicer(tagPolicy, nil); err != nil {
+		return nil, err
+	}
+	return &TagSelector{tagPolicy: tagPolicy, filter: filter}, nil
+}
+
+// Filter returns the tags kept by the regex filter of the policy.
+func (s *TagSelector) Filter(tags []string) []string {
+	return s.filter.Apply(tags)
+}
+
+// Matches tells whether a single tag, like a pushed one, is selected by the policy: kept by
+// the regex filter and not dropped by the ordering, as the tags out of the semver range or
+// not numeric are. The time based orderings keep the tag, its time being the push itself.
+func (s *TagSelector) Matches(tag string) bool {
+	tags := s.Filter([]string{tag})
+	if len(tags) == 0 {
+		return false
+	}
+	if IsTimeBased(s.tagPolicy.GetTagOrder()) {
+		return true
+	}
+	sorted, err := s.Sort(tags, nil)
+	return err == nil && len(sorted) == 1
+}
+
+// Sort orders the filtered tags from the newest to the oldest. The values
+// extracted from the tags are used as sort keys, unless the order is time based.
+func (s *TagSelector) Sort(tags []string, times map[string]time.Time) ([]string, error) {
+	policer, err := NewPolicer(s.tagPolicy, times)
+	if err != nil {
+		return nil, err
+	}
+	if !s.filter.HasExtract() || IsTimeBased(s.tagPolicy.GetTagOrder()) {
+		return policer.Sort(tags), nil
+	}
+	keys := make([]string, 0, len(tags))
+	keyTags := make(map[string][]string, len(tags))
+	for _, tag := range tags {
+		key := s.filter.Extract(tag)
+		if _, ok := keyTags[key]; !ok {
+			keys = append(keys, key)
+		}
+		keyTags[key] = append(keyTags[key], tag)
+	}
+	sorted := make([]string, 0, len(tags))
+	for _, key := range policer.Sort(keys) {
+		sorted = append(sorted, keyTags[key]...)
+	}
+	return sorted, nil
+}
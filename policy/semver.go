@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/devtron-labs/source-controller/bean"
+	"sort"
+)
+
+// SemVer orders the tags by semantic version, highest first. Tags which are
+// not valid versions, or do not match the constraint when one is set, are dropped.
+type SemVer struct {
+	Constraint        *semver.Constraints
+	IncludePrerelease bool
+}
+
+// NewSemVer returns a SemVer policer for the given range, an empty range
+// matches every version.
+func NewSemVer(semverRange string, includePrerelease bool) (*SemVer, error) {
+	p := &SemVer{IncludePrerelease: includePrerelease}
+	if semverRange == "" {
+		return p, nil
+	}
+	constraint, err := semver.NewConstraint(semverRange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid semver range %q: %w", semverRange, err)
+	}
+	p.Constraint = constraint
+	return p, nil
+}
+
+// Sort implements Policer.
+func (p *SemVer) Sort(tags []string) []string {
+	versions := make(map[string]*semver.Version, len(tags))
+	sorted := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		version, err := bean.ParseVersion(tag)
+		if err != nil || !p.matches(version) {
+			continue
+		}
+		versions[tag] = version
+		sorted = append(sorted, tag)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return versions[sorted[i]].GreaterThan(versions[sorted[j]])
+	})
+	return sorted
+}
+
+func (p *SemVer) matches(version *semver.Version) bool {
+	if p.Constraint == nil {
+		return true
+	}
+	if p.Constraint.Check(version) {
+		return true
+	}
+	if !p.IncludePrerelease || version.Prerelease() == "" {
+		return false
+	}
+	release, err := version.SetPrerelease("")
+	if err != nil {
+		return false
+	}
+	return p.Constraint.Check(&release)
+}
@@ -0,0 +1,26 @@
+package policy
+
+import (
+	"sort"
+	"time"
+)
+
+// Time orders the tags by the time they were created or pushed at, newest
+// first. Tags without a known time are dropped.
+type Time struct {
+	Times map[string]time.Time
+}
+
+// Sort implements Policer.
+func (p Time) Sort(tags []string) []string {
+	sorted := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if t, ok := p.Times[tag]; ok && !t.IsZero() {
+			sorted = append(sorted, tag)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return p.Times[sorted[i]].After(p.Times[sorted[j]])
+	})
+	return sorted
+}
@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegistryRateLimiter holds a token bucket per registry host, shared by all the sources
+// calling that host, so that a registry is not sent more requests than it accepts.
+// A host which throttles us, with a 429 or a 503 and Retry-After, is backed off: no
+// request is sent to it until the backoff ends and every call fails with a ThrottledError.
+type RegistryRateLimiter interface {
+	Wait(ctx context.Context, host string) error
+	Transport(base http.RoundTripper) http.RoundTripper
+	Throttle(host string, statusCode int, retryAfter time.Duration) *ThrottledError
+}
+
+type RegistryRateLimiterImpl struct {
+	logger     *zap.SugaredLogger
+	config     *RegistryRateLimitConfig
+	hostLimits map[string]hostLimit
+	limiters   sync.Map
+	// backoffs holds the backoff of the throttled hosts, guarded by backoffLock
+	backoffs    map[string]*hostBackoff
+	backoffLock sync.Mutex
+}
+
+type hostBackoff struct {
+	throttledErr *ThrottledError
+	// attempts is the number of times in a row the host throttled us
+	attempts int
+}
+
+type RegistryRateLimitConfig struct {
+	// RequestsPerSecond and Burst apply to every host without its own limit, 0 requests per second disables the limit
+	RequestsPerSecond float64 `env:"REGISTRY_RATE_LIMIT_PER_SECOND" envDefault:"10"`
+	Burst             int     `env:"REGISTRY_RATE_LIMIT_BURST" envDefault:"20"`
+	// HostRateLimits overrides the limit of some hosts, as comma separated host=requestsPerSecond[:burst],
+	// e.g. registry-1.docker.io=2:5,harbor.example.com=1. The host is the one the requests are sent to.
+	HostRateLimits string `env:"REGISTRY_HOST_RATE_LIMITS" envDefault:""`
+	// ThrottleBackoffSeconds is the backoff of a host throttling us without Retry-After, doubled each
+	// time in a row it does, ThrottleBackoffMaxSeconds caps it as well as the Retry-After of the host
+	ThrottleBackoffSeconds    int `env:"REGISTRY_THROTTLE_BACKOFF_SECONDS" envDefault:"30"`
+	ThrottleBackoffMaxSeconds int `env:"REGISTRY_THROTTLE_BACKOFF_MAX_SECONDS" envDefault:"900"`
+}
+
+type hostLimit struct {
+	requestsPerSecond float64
+	burst             int
+}
+
+func NewRegistryRateLimiterImpl(logger *zap.SugaredLogger) (*RegistryRateLimiterImpl, error) {
+	cfg := &RegistryRateLimitConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing registry rate limit config", "err", err)
+		return nil, err
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	hostLimits, err := parseHostRateLimits(cfg.HostRateLimits, cfg.Burst)
+	if err != nil {
+		logger.Errorw("error in parsing registry host rate limits", "err", err, "hostRateLimits", cfg.HostRateLimits)
+		return nil, err
+	}
+	return &RegistryRateLimiterImpl{
+		logger:     logger,
+		config:     cfg,
+		hostLimits: hostLimits,
+		backoffs:   make(map[string]*hostBackoff),
+	}, nil
+}
+
+func parseHostRateLimits(hostRateLimits string, defaultBurst int) (map[string]hostLimit, error) {
+	hostLimits := make(map[string]hostLimit)
+	for _, hostRateLimit := range strings.Split(hostRateLimits, ",") {
+		hostRateLimit = strings.TrimSpace(hostRateLimit)
+		if hostRateLimit == "" {
+			continue
+		}
+		host, limit, found := strings.Cut(hostRateLimit, "=")
+		if !found || host == "" {
+			return nil, fmt.Errorf("invalid host rate limit %q, expected host=requestsPerSecond[:burst]", hostRateLimit)
+		}
+		requestsPerSecond, burst, hasBurst := strings.Cut(limit, ":")
+		parsedLimit := hostLimit{burst: defaultBurst}
+		var err error
+		parsedLimit.requestsPerSecond, err = strconv.ParseFloat(requestsPerSecond, 64)
+		if err != nil || parsedLimit.requestsPerSecond < 0 {
+			return nil, fmt.Errorf("invalid requests per second in host rate limit %q", hostRateLimit)
+		}
+		if hasBurst {
+			parsedLimit.burst, err = strconv.Atoi(burst)
+			if err != nil || parsedLimit.burst < 1 {
+				return nil, fmt.Errorf("invalid burst in host rate limit %q", hostRateLimit)
+			}
+		}
+		hostLimits[strings.ToLower(host)] = parsedLimit
+	}
+	return hostLimits, nil
+}
+
+func (impl *RegistryRateLimiterImpl) getLimiter(host string) *rate.Limiter {
+	host = strings.ToLower(host)
+	if limiter, ok := impl.limiters.Load(host); ok {
+		return limiter.(*rate.Limiter)
+	}
+	limit, ok := impl.hostLimits[host]
+	if !ok {
+		limit = hostLimit{requestsPerSecond: impl.config.RequestsPerSecond, burst: impl.config.Burst}
+	}
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if limit.requestsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(limit.requestsPerSecond), limit.burst)
+	}
+	actual, _ := impl.limiters.LoadOrStore(host, limiter)
+	return actual.(*rate.Limiter)
+}
+
+// Wait blocks until a request may be sent to the host, or the context is done.
+func (impl *RegistryRateLimiterImpl) Wait(ctx context.Context, host string) error {
+	return impl.getLimiter(host).Wait(ctx)
+}
+
+// Throttle backs off the host for retryAfter, or for the exponential backoff of the
+// host when it is not given, and returns the error the calls to the host fail with.
+func (impl *RegistryRateLimiterImpl) Throttle(host string, statusCode int, retryAfter time.Duration) *ThrottledError {
+	host = strings.ToLower(host)
+	maxBackoff := time.Duration(impl.config.ThrottleBackoffMaxSeconds) * time.Second
+	impl.backoffLock.Lock()
+	defer impl.backoffLock.Unlock()
+	backoff, ok := impl.backoffs[host]
+	if !ok {
+		backoff = &hostBackoff{}
+		impl.backoffs[host] = backoff
+	}
+	backoff.attempts++
+	if retryAfter <= 0 {
+		retryAfter = time.Duration(impl.config.ThrottleBackoffSeconds) * time.Second
+		for i := 1; i < backoff.attempts && retryAfter < maxBackoff; i++ {
+			retryAfter *= 2
+		}
+	}
+	if retryAfter > maxBackoff {
+		retryAfter = maxBackoff
+	}
+	backoff.throttledErr = &ThrottledError{Host: host, StatusCode: statusCode, Until: time.Now().Add(retryAfter)}
+	metrics.RecordRegistryThrottled(host, backoff.throttledErr.Until)
+	impl.logger.Warnw("registry host throttled, backing off", "host", host, "statusCode", statusCode, "until", backoff.throttledErr.Until)
+	return backoff.throttledErr
+}
+
+// getThrottle returns the error of the host while it is backed off.
+func (impl *RegistryRateLimiterImpl) getThrottle(host string) *ThrottledError {
+	impl.backoffLock.Lock()
+	defer impl.backoffLock.Unlock()
+	backoff, ok := impl.backoffs[strings.ToLower(host)]
+	if !ok || !time.Now().Before(backoff.throttledErr.Until) {
+		return nil
+	}
+	return backoff.throttledErr
+}
+
+// resetThrottle forgets the backoff of the host once it answers without throttling us.
+func (impl *RegistryRateLimiterImpl) resetThrottle(host string) {
+	impl.backoffLock.Lock()
+	defer impl.backoffLock.Unlock()
+	delete(impl.backoffs, strings.ToLower(host))
+}
+
+// Transport returns a round tripper waiting for the limiter of the host of every request before
+// sending it. The requests to a backed off host fail right away, as do the throttled responses.
+func (impl *RegistryRateLimiterImpl) Transport(base http.RoundTripper) http.RoundTripper {
+	return &rateLimitedTransport{rateLimiter: impl, base: base}
+}
+
+type rateLimitedTransport struct {
+	rateLimiter *RegistryRateLimiterImpl
+	base        http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if throttledErr := t.rateLimiter.getThrottle(host); throttledErr != nil {
+		return nil, throttledErr
+	}
+	if err := t.rateLimiter.Wait(req.Context(), host); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		metrics.RecordRegistryRequest(host, getRegistryOperation(req), 0)
+		return resp, err
+	}
+	metrics.RecordRegistryRequest(host, getRegistryOperation(req), resp.StatusCode)
+	if isThrottled(resp) {
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		_ = resp.Body.Close()
+		return nil, t.rateLimiter.Throttle(host, resp.StatusCode, retryAfter)
+	}
+	if resp.StatusCode < http.StatusInternalServerError {
+		t.rateLimiter.resetThrottle(host)
+	}
+	return resp, nil
+}
+
+// getRegistryOperation names the registry api called by the request, for the metrics.
+func getRegistryOperation(req *http.Request) string {
+	// the ECR api names the operation in the target header, e.g. AmazonEC2ContainerRegistry_V20150921.DescribeImages
+	if target := req.Header.Get("X-Amz-Target"); target != "" {
+		return target[strings.LastIndex(target, ".")+1:]
+	}
+	path := req.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		return "list_tags"
+	case strings.Contains(path, "/manifests/"):
+		return strings.ToLower(req.Method) + "_manifest"
+	case strings.Contains(path, "/blobs/"):
+		return strings.ToLower(req.Method) + "_blob"
+	case path == "/v2/" || path == "/v2":
+		return "ping"
+	case strings.Contains(path, "token") || strings.Contains(path, "login"):
+		return "token"
+	}
+	return "other"
+}
@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRateLimiter(t *testing.T, hostRateLimits string) *RegistryRateLimiterImpl {
+	cfg := &RegistryRateLimitConfig{RequestsPerSecond: 10, Burst: 20, ThrottleBackoffSeconds: 30, ThrottleBackoffMaxSeconds: 900}
+	hostLimits, err := parseHostRateLimits(hostRateLimits, cfg.Burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RegistryRateLimiterImpl{
+		logger:     zap.NewNop().Sugar(),
+		config:     cfg,
+		hostLimits: hostLimits,
+		backoffs:   make(map[string]*hostBackoff),
+	}
+}
+
+func TestParseHostRateLimits(t *testing.T) {
+	tests := []struct {
+		hostRateLimits string
+		want           map[string]hostLimit
+		wantErr        bool
+	}{
+		{"", map[string]hostLimit{}, false},
+		{"registry-1.docker.io=2:5, Harbor.example.com=1.5,", map[string]hostLimit{
+			"registry-1.docker.io": {requestsPerSecond: 2, burst: 5},
+			"harbor.example.com":   {requestsPerSecond: 1.5, burst: 20},
+		}, false},
+		{"quay.io=0", map[string]hostLimit{"quay.io": {requestsPerSecond: 0, burst: 20}}, false},
+		{"quay.io", nil, true},
+		{"=2", nil, true},
+		{"quay.io=fast", nil, true},
+		{"quay.io=-1", nil, true},
+		{"quay.io=2:0", nil, true},
+		{"quay.io=2:many", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHostRateLimits(tt.hostRateLimits, 20)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHostRateLimits(%q) error = %v, wantErr %v", tt.hostRateLimits, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseHostRateLimits(%q) = %v, want %v", tt.hostRateLimits, got, tt.want)
+		}
+	}
+}
+
+func TestGetLimiter(t *testing.T) {
+	impl := newTestRateLimiter(t, "registry-1.docker.io=2:5,quay.io=0")
+	tests := []struct {
+		host      string
+		wantLimit rate.Limit
+		wantBurst int
+	}{
+		{"Registry-1.docker.io", 2, 5},
+		{"quay.io", rate.Inf, 0},
+		{"ghcr.io", 10, 20},
+	}
+	for _, tt := range tests {
+		limiter := impl.getLimiter(tt.host)
+		if limiter.Limit() != tt.wantLimit || limiter.Burst() != tt.wantBurst {
+			t.Errorf("getLimiter(%q) = %v:%d, want %v:%d", tt.host, limiter.Limit(), limiter.Burst(), tt.wantLimit, tt.wantBurst)
+		}
+		if impl.getLimiter(tt.host) != limiter {
+			t.Errorf("getLimiter(%q) returned another limiter for the same host", tt.host)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	impl := newTestRateLimiter(t, "")
+	tests := []struct {
+		name        string
+		retryAfter  time.Duration
+		wantBackoff time.Duration
+	}{
+		{"first backoff", 0, 30 * time.Second},
+		{"doubled", 0, time.Minute},
+		{"doubled again", 0, 2 * time.Minute},
+		{"retry after of the host", 45 * time.Second, 45 * time.Second},
+		{"retry after capped", time.Hour, 15 * time.Minute},
+		{"doubled up to the cap", 0, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		throttledErr := impl.Throttle("Harbor.example.com", http.StatusTooManyRequests, tt.retryAfter)
+		backoff := throttledErr.Until.Sub(before)
+		if backoff < tt.wantBackoff || backoff > tt.wantBackoff+time.Second {
+			t.Errorf("%s: Throttle() backs off for %s, want %s", tt.name, backoff, tt.wantBackoff)
+		}
+		if throttledErr.Host != "harbor.example.com" || throttledErr.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("%s: Throttle() = %+v", tt.name, throttledErr)
+		}
+	}
+	impl.resetThrottle("harbor.example.com")
+	if throttledErr := impl.Throttle("harbor.example.com", http.StatusTooManyRequests, 0); throttledErr.Until.Sub(time.Now()) > 31*time.Second {
+		t.Errorf("Throttle() after a reset backs off until %s, want the first backoff", throttledErr.Until)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var requests, statusCode atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if code := int(statusCode.Load()); code != 0 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := newTestRateLimiter(t, "")
+	client := &http.Client{Transport: impl.Transport(http.DefaultTransport)}
+
+	if resp, err := client.Get(server.URL + "/v2/"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Get() = %v, %v, want the response of the registry", resp, err)
+	}
+	for _, throttledCode := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		statusCode.Store(int32(throttledCode))
+		before := time.Now()
+		_, err := client.Get(server.URL + "/v2/devtron/app/tags/list")
+		throttledErr, ok := AsThrottledError(err)
+		if !ok || throttledErr.StatusCode != throttledCode {
+			t.Fatalf("Get() error = %v, want a throttled error of status %d", err, throttledCode)
+		}
+		if backoff := throttledErr.Until.Sub(before); backoff < 2*time.Minute || backoff > 2*time.Minute+time.Second {
+			t.Errorf("Get() backs off the host for %s, want the Retry-After of the host", backoff)
+		}
+
+		// while the host is backed off the requests fail without reaching it
+		statusCode.Store(0)
+		sent := requests.Load()
+		_, err = client.Get(server.URL + "/v2/devtron/app/tags/list")
+		if fastErr, ok := AsThrottledError(err); !ok || fastErr != throttledErr {
+			t.Errorf("Get() while backed off error = %v, want the throttled error of the host", err)
+		}
+		if requests.Load() != sent {
+			t.Error("Get() while backed off sent the request to the host")
+		}
+		impl.resetThrottle(serverUrl.Host)
+	}
+}
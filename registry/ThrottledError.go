@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ThrottledError is returned for the calls to a registry host which throttled us,
+// until the end of its backoff no request is sent to the host.
+type ThrottledError struct {
+	Host       string
+	StatusCode int
+	Until      time.Time
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("registry %s is throttling requests (status %d), backing off until %s", e.Host, e.StatusCode, e.Until.Format(time.RFC3339))
+}
+
+// AsThrottledError returns the ThrottledError wrapped in err, if any.
+func AsThrottledError(err error) (*ThrottledError, bool) {
+	var throttledErr *ThrottledError
+	if errors.As(err, &throttledErr) {
+		return throttledErr, true
+	}
+	return nil, false
+}
+
+// isThrottled tells whether the response asks us to slow down: a 429, or a 503
+// carrying a Retry-After, 503 without it being left to the retries of the client.
+func isThrottled(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// parseRetryAfter reads the Retry-After header, given either in seconds or as an http date.
+func parseRetryAfter(retryAfter string, now time.Time) (time.Duration, bool) {
+	if retryAfter == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(retryAfter)
+	if err != nil {
+		return 0, false
+	}
+	if date.Before(now) {
+		return 0, true
+	}
+	return date.Sub(now), true
+}
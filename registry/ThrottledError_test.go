@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"Fri, 01 Sep 2023 12:00:30 GMT": 30 * time.Second,
+		"Fri, 01 Sep 2023 11:00:00 GMT": 0,
+	}
+	for retryAfter, want := range cases {
+		got, ok := parseRetryAfter(retryAfter, now)
+		if !ok || got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v", retryAfter, got, ok, want)
+		}
+	}
+	for _, retryAfter := range []string{"", "-1", "soon"} {
+		if _, ok := parseRetryAfter(retryAfter, now); ok {
+			t.Errorf("parseRetryAfter(%q) should not be valid", retryAfter)
+		}
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	cases := []struct {
+		statusCode int
+		retryAfter string
+		want       bool
+	}{
+		{http.StatusTooManyRequests, "", true},
+		{http.StatusServiceUnavailable, "10", true},
+		{http.StatusServiceUnavailable, "", false},
+		{http.StatusUnauthorized, "10", false},
+	}
+	for _, c := range cases {
+		resp := &http.Response{StatusCode: c.statusCode, Header: http.Header{}}
+		if c.retryAfter != "" {
+			resp.Header.Set("Retry-After", c.retryAfter)
+		}
+		if got := isThrottled(resp); got != c.want {
+			t.Errorf("isThrottled(%d, %q) = %v, want %v", c.statusCode, c.retryAfter, got, c.want)
+		}
+	}
+	if _, ok := AsThrottledError(fmt.Errorf("listing tags: %w", &ThrottledError{Host: "registry-1.docker.io"})); !ok {
+		t.Errorf("AsThrottledError should find a wrapped ThrottledError")
+	}
+}
@@ -0,0 +1,172 @@
+package ecr
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/bean"
+	"go.uber.org/zap"
+	"time"
+)
+
+// PushEventHandler runs the images reported pushed by the registries through the
+// notification pipeline of their sources, it is implemented by the
+// SourceControllerService of the main package.
+type PushEventHandler interface {
+	HandlePushEvents(ctx context.Context, events []bean.PushEvent) ([]*bean.PushEventReport, error)
+}
+
+// EcrPushEventConsumer long polls an SQS queue receiving the "ECR Image Action" events of
+// EventBridge and notifies the pushed images to their sources. A message is deleted once
+// its image is settled, otherwise it is received again after the visibility timeout of
+// the queue, which should have a redrive policy for the messages failing for good.
+type EcrPushEventConsumer interface {
+	Start()
+	Stop()
+}
+
+type EcrPushEventConsumerImpl struct {
+	logger           *zap.SugaredLogger
+	pushEventHandler PushEventHandler
+	config           *EcrPushEventConfig
+	cancel           context.CancelFunc
+	done             chan struct{}
+}
+
+type EcrPushEventConfig struct {
+	// QueueUrl is the SQS queue to consume, the push events are not consumed when not set
+	QueueUrl    string `env:"ECR_PUSH_EVENTS_QUEUE_URL" envDefault:""`
+	QueueRegion string `env:"ECR_PUSH_EVENTS_QUEUE_REGION" envDefault:""`
+	// EndpointUrl overrides the SQS endpoint, like http://localhost:9324 for a local stand-in
+	EndpointUrl          string `env:"ECR_PUSH_EVENTS_QUEUE_ENDPOINT_URL" envDefault:""`
+	WaitTimeSeconds      int32  `env:"ECR_PUSH_EVENTS_QUEUE_WAIT_TIME_SECONDS" envDefault:"20"`
+	MaxMessages          int32  `env:"ECR_PUSH_EVENTS_QUEUE_MAX_MESSAGES" envDefault:"10"`
+	RetryIntervalSeconds int    `env:"ECR_PUSH_EVENTS_QUEUE_RETRY_INTERVAL_SECONDS" envDefault:"5"`
+}
+
+func NewEcrPushEventConsumerImpl(logger *zap.SugaredLogger,
+	pushEventHandler PushEventHandler) (*EcrPushEventConsumerImpl, error) {
+	cfg := &EcrPushEventConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing ecr push event config", "err", err)
+		return nil, err
+	}
+	return &EcrPushEventConsumerImpl{
+		logger:           logger,
+		pushEventHandler: pushEventHandler,
+		config:           cfg,
+		done:             make(chan struct{}),
+	}, nil
+}
+
+// ecrImageActionEvent is an "ECR Image Action" event of EventBridge, the body of the messages.
+type ecrImageActionEvent struct {
+	DetailType string    `json:"detail-type"`
+	Source     string    `json:"source"`
+	Account    string    `json:"account"`
+	Region     string    `json:"region"`
+	Time       time.Time `json:"time"`
+	Detail     struct {
+		Result         string `json:"result"`
+		RepositoryName string `json:"repository-name"`
+		ImageDigest    string `json:"image-digest"`
+		ActionType     string `json:"action-type"`
+		ImageTag       string `json:"image-tag"`
+	} `json:"detail"`
+}
+
+// Start consumes the queue in the background.
+func (impl *EcrPushEventConsumerImpl) Start() {
+	if impl.config.QueueUrl == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg, err := loadAwsConfig(ctx, &AwsS3BaseConfig{Region: impl.config.QueueRegion, EndpointUrl: impl.config.EndpointUrl})
+	if err != nil {
+		impl.logger.Errorw("error in loading aws config of ecr push event queue", "err", err, "queueUrl", impl.config.QueueUrl)
+		cancel()
+		return
+	}
+	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if impl.config.EndpointUrl != "" {
+			o.BaseEndpoint = aws.String(impl.config.EndpointUrl)
+		}
+	})
+	impl.logger.Infow("consuming ecr push events", "queueUrl", impl.config.QueueUrl)
+	impl.cancel = cancel
+	go impl.consume(ctx, client)
+}
+
+func (impl *EcrPushEventConsumerImpl) consume(ctx context.Context, client *sqs.Client) {
+	defer close(impl.done)
+	for ctx.Err() == nil {
+		output, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(impl.config.QueueUrl),
+			MaxNumberOfMessages: impl.config.MaxMessages,
+			WaitTimeSeconds:     impl.config.WaitTimeSeconds,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			impl.logger.Errorw("error in receiving ecr push events", "err", err, "queueUrl", impl.config.QueueUrl)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(impl.config.RetryIntervalSeconds) * time.Second):
+			}
+			continue
+		}
+		for _, message := range output.Messages {
+			if !impl.handleMessage(ctx, aws.ToString(message.Body)) {
+				continue
+			}
+			_, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(impl.config.QueueUrl),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil {
+				impl.logger.Errorw("error in deleting ecr push event", "err", err, "messageId", aws.ToString(message.MessageId))
+			}
+		}
+	}
+}
+
+// handleMessage notifies the image pushed by the message and tells whether the message is
+// done with: its image is settled, or it is not the successful push of a tagged image.
+func (impl *EcrPushEventConsumerImpl) handleMessage(ctx context.Context, body string) bool {
+	event := ecrImageActionEvent{}
+	err := json.Unmarshal([]byte(body), &event)
+	if err != nil {
+		impl.logger.Errorw("error in decoding ecr push event, dropping it", "err", err, "body", body)
+		return true
+	}
+	if event.DetailType != "ECR Image Action" || event.Detail.ActionType != "PUSH" || event.Detail.Result != "SUCCESS" || event.Detail.ImageTag == "" {
+		return true
+	}
+	pushEvent := bean.PushEvent{
+		Receiver:     "ecr",
+		RegistryHost: getHostUrlForEcr(event.Account, event.Region),
+		Repository:   event.Detail.RepositoryName,
+		Tag:          event.Detail.ImageTag,
+		Digest:       event.Detail.ImageDigest,
+		PushedOn:     event.Time,
+	}
+	reports, err := impl.pushEventHandler.HandlePushEvents(ctx, []bean.PushEvent{pushEvent})
+	if err != nil {
+		impl.logger.Errorw("error in handling ecr push event", "err", err, "event", pushEvent)
+		return false
+	}
+	return len(reports) == 1 && reports[0].Settled
+}
+
+// Stop stops consuming the queue, the message being handled is received again later.
+func (impl *EcrPushEventConsumerImpl) Stop() {
+	if impl.cancel == nil {
+		return
+	}
+	impl.cancel()
+	<-impl.done
+}
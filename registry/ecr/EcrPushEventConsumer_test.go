@@ -0,0 +1,96 @@
+package ecr
+
+import (
+	"context"
+	"errors"
+	"github.com/devtron-labs/source-controller/bean"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubPushEventHandler records the push events and settles them as told.
+type stubPushEventHandler struct {
+	settled bool
+	err     error
+	events  []bean.PushEvent
+}
+
+func (h *stubPushEventHandler) HandlePushEvents(ctx context.Context, events []bean.PushEvent) ([]*bean.PushEventReport, error) {
+	h.events = append(h.events, events...)
+	if h.err != nil {
+		return nil, h.err
+	}
+	reports := make([]*bean.PushEventReport, 0, len(events))
+	for _, event := range events {
+		reports = append(reports, &bean.PushEventReport{Event: event, Settled: h.settled})
+	}
+	return reports, nil
+}
+
+// ecrPushMessage is the body of the message EventBridge sends to the queue for an ECR push.
+const ecrPushMessage = `{
+  "version": "0",
+  "id": "13cde686-328b-6117-af20-0e5566167482",
+  "detail-type": "ECR Image Action",
+  "source": "aws.ecr",
+  "account": "123456789012",
+  "time": "2024-03-01T10:00:00Z",
+  "region": "eu-west-1",
+  "resources": [],
+  "detail": {
+    "result": "SUCCESS",
+    "repository-name": "devtron/app",
+    "image-digest": "sha256:7f5b2640fe6fb4f46592dfd3410c4a79dac4f89e4782432e0378abcd1234",
+    "action-type": "PUSH",
+    "image-tag": "1.1.0"
+  }
+}`
+
+func TestHandleMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		handler    *stubPushEventHandler
+		wantDelete bool
+		wantEvents int
+	}{
+		{"settled push", ecrPushMessage, &stubPushEventHandler{settled: true}, true, 1},
+		{"unsettled push", ecrPushMessage, &stubPushEventHandler{}, false, 1},
+		{"handler error", ecrPushMessage, &stubPushEventHandler{settled: true, err: errors.New("connection refused")}, false, 1},
+		{"malformed body", `{"detail-type": `, &stubPushEventHandler{}, true, 0},
+		{"delete action", strings.Replace(ecrPushMessage, `"PUSH"`, `"DELETE"`, 1), &stubPushEventHandler{}, true, 0},
+		{"failed push", strings.Replace(ecrPushMessage, `"SUCCESS"`, `"FAILURE"`, 1), &stubPushEventHandler{}, true, 0},
+		{"untagged push", strings.Replace(ecrPushMessage, `"1.1.0"`, `""`, 1), &stubPushEventHandler{}, true, 0},
+		{"other event", strings.Replace(ecrPushMessage, `"ECR Image Action"`, `"ECR Image Scan"`, 1), &stubPushEventHandler{}, true, 0},
+	}
+	for _, tt := range tests {
+		impl := &EcrPushEventConsumerImpl{logger: zap.NewNop().Sugar(), pushEventHandler: tt.handler, config: &EcrPushEventConfig{}}
+		if got := impl.handleMessage(context.Background(), tt.body); got != tt.wantDelete {
+			t.Errorf("%s: handleMessage() = %v, want %v", tt.name, got, tt.wantDelete)
+		}
+		if len(tt.handler.events) != tt.wantEvents {
+			t.Errorf("%s: handleMessage() handled %d push events, want %d", tt.name, len(tt.handler.events), tt.wantEvents)
+		}
+	}
+
+	handler := &stubPushEventHandler{settled: true}
+	impl := &EcrPushEventConsumerImpl{logger: zap.NewNop().Sugar(), pushEventHandler: handler, config: &EcrPushEventConfig{}}
+	impl.handleMessage(context.Background(), ecrPushMessage)
+	want := bean.PushEvent{
+		Receiver:     "ecr",
+		RegistryHost: "123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+		Repository:   "devtron/app",
+		Tag:          "1.1.0",
+		Digest:       "sha256:7f5b2640fe6fb4f46592dfd3410c4a79dac4f89e4782432e0378abcd1234",
+		PushedOn:     time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if len(handler.events) != 1 || handler.events[0] != want {
+		t.Errorf("handleMessage() push events = %+v, want %+v", handler.events, want)
+	}
+	deployConfig := bean.DeployConfig{RepoName: "devtron/app", EcrConfig: bean.EcrConfig{AwsRegistryId: "123456789012", AwsRegion: "eu-west-1"}}
+	if !deployConfig.MatchesRepository(want.RegistryHost, want.Repository) {
+		t.Error("the push event does not match the ecr source of its account and region")
+	}
+}
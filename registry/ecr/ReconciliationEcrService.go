@@ -2,32 +2,45 @@ package ecr
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/bean"
 	common2 "github.com/devtron-labs/source-controller/common"
+	"github.com/devtron-labs/source-controller/policy"
+	"github.com/devtron-labs/source-controller/registry"
 	repository "github.com/devtron-labs/source-controller/sql/repo"
 	"go.uber.org/zap"
+	"net/http"
+	"time"
 )
 
 type ReconciliationEcrService interface {
-	GetAwsClientFromCred(s3BaseConfig *AwsS3BaseConfig) (*ecr.Client, error)
-	GetAllImagesList(client *ecr.Client, registryId, repositoryName string, externalCiPipelineId int, hostUrl string) error
+	ReconcileSource(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error)
+	GetAwsClientFromCred(ctx context.Context, s3BaseConfig *AwsS3BaseConfig) (*ecr.Client, error)
+	GetAllImagesList(ctx context.Context, client ecr.DescribeImagesAPIClient, registryId, repositoryName string) ([]types.ImageDetail, error)
+	ResolveRegistry(ctx context.Context, deployConfig bean.DeployConfig) (registryId string, region string, err error)
 }
 
 type ReconciliationEcrServiceImpl struct {
 	logger               *zap.SugaredLogger
 	ciArtifactRepository repository.CiArtifactRepository
 	commonService        common2.CommonService
+	registryRateLimiter  registry.RegistryRateLimiter
 	config               *ReconciliationConfig
 }
 
 func NewReconciliationServiceImpl(logger *zap.SugaredLogger,
 	ciArtifactRepository repository.CiArtifactRepository,
-	commonService common2.CommonService) *ReconciliationEcrServiceImpl {
+	commonService common2.CommonService,
+	registryRateLimiter registry.RegistryRateLimiter) *ReconciliationEcrServiceImpl {
 	cfg := &ReconciliationConfig{}
 	err := env.Parse(cfg)
 	if err != nil {
@@ -37,6 +50,7 @@ func NewReconciliationServiceImpl(logger *zap.SugaredLogger,
 		logger:               logger,
 		ciArtifactRepository: ciArtifactRepository,
 		commonService:        commonService,
+		registryRateLimiter:  registryRateLimiter,
 		config:               cfg,
 	}
 	return reconciliationEcrServiceImpl
@@ -47,7 +61,7 @@ type ReconciliationConfig struct {
 }
 type AwsS3BaseConfig struct {
 	AccessKey   string `json:"accessKey"`
-	Passkey     string `json:"passkey"`
+	Passkey     string `json:"passkey" secretData:"-"`
 	EndpointUrl string `json:"endpointUrl"`
 	IsInSecure  bool   `json:"isInSecure"`
 	Region      string `json:"region"`
@@ -58,47 +72,146 @@ type ImageIdentifier struct {
 	ImageTag string `json:"imageTag"`
 }
 
-func (impl *ReconciliationEcrServiceImpl) GetAwsClientFromCred(s3BaseConfig *AwsS3BaseConfig) (*ecr.Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s3BaseConfig.AccessKey, s3BaseConfig.Passkey, "")))
+// ReconcileSource lists the tagged images of an ECR repository, picks the newest
+// ImageShowCount of them and notifies the ones not yet known to the orchestrator.
+func (impl *ReconciliationEcrServiceImpl) ReconcileSource(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error) {
+	report := bean.NewReconcileReport(deployConfig)
+	client, region, err := impl.getAwsClient(ctx, getAwsBaseConfig(deployConfig))
 	if err != nil {
-		impl.logger.Errorw("error in loading default config from aws ecr credentials", "err", err)
-		return nil, err
+		impl.logger.Errorw("error in getting ecr client", "err", err, "repoName", deployConfig.RepoName, "externalCiId", deployConfig.ExternalCiId)
+		return report, err
+	}
+	imageDetails, err := impl.GetAllImagesList(ctx, client, deployConfig.AwsRegistryId, deployConfig.RepoName)
+	if err != nil {
+		return report, err
+	}
+	if len(imageDetails) == 0 {
+		return report, nil
+	}
+	hostUrl := deployConfig.RegistryURL
+	if hostUrl == "" {
+		// the region may come from the default chain rather than the source
+		if region == "" {
+			return report, errors.New("no aws region configured for the source nor resolved from the default aws config")
+		}
+		hostUrl = getHostUrlForEcr(aws.ToString(imageDetails[0].RegistryId), region)
+	}
+	err = impl.filterImages(imageDetails, deployConfig, report)
+	if err != nil {
+		impl.logger.Errorw("error in filtering images", "err", err)
+		return report, err
 	}
-	cfg.Region = s3BaseConfig.Region
-	// Create ECR client from Config
-	svcClient := ecr.NewFromConfig(cfg)
 
-	return svcClient, err
+	err = impl.commonService.ReportDiscoveredImages(ctx, deployConfig, hostUrl, report)
+	if err != nil {
+		impl.logger.Errorw("error in notifying discovered images", "err", err, "repoName", deployConfig.RepoName, "externalCiId", deployConfig.ExternalCiId)
+		return report, err
+	}
+	return report, nil
 }
 
-func (impl *ReconciliationEcrServiceImpl) GetAllImagesList(client *ecr.Client, registryId, repositoryName string, externalCiPipelineId int, hostUrl string) error {
-	// TODO: Move Host url in another service
-	hostUrl = getHostUrlForEcr(registryId, "region")
-	// TODO: move it
-	listImageInput := &ecr.ListImagesInput{
-		RepositoryName: &repositoryName,
-		RegistryId:     &registryId,
+// ResolveRegistry returns the registry id and the region of the ECR registry of the source, the
+// ones it leaves empty being those of the credentials: the default registry of their account and
+// the region of the default aws config.
+func (impl *ReconciliationEcrServiceImpl) ResolveRegistry(ctx context.Context, deployConfig bean.DeployConfig) (string, string, error) {
+	client, region, err := impl.getAwsClient(ctx, getAwsBaseConfig(deployConfig))
+	if err != nil {
+		return "", "", err
+	}
+	if region == "" {
+		return "", "", errors.New("no aws region configured for the source nor resolved from the default aws config")
 	}
-	listImagesOutput, err := client.ListImages(context.Background(), listImageInput)
+	if deployConfig.AwsRegistryId != "" {
+		return deployConfig.AwsRegistryId, region, nil
+	}
+	output, err := client.DescribeRegistry(ctx, &ecr.DescribeRegistryInput{})
 	if err != nil {
-		impl.logger.Errorw("error in list images from ecr", "err", err, "repoName", repositoryName, "registryId", registryId)
-		return err
+		impl.logger.Errorw("error in describing ecr registry", "err", err, "repoName", deployConfig.RepoName, "externalCiId", deployConfig.ExternalCiId)
+		return "", "", impl.throttleOnThrottlingException(err)
+	}
+	return aws.ToString(output.RegistryId), region, nil
+}
+
+func getAwsBaseConfig(deployConfig bean.DeployConfig) *AwsS3BaseConfig {
+	return &AwsS3BaseConfig{
+		AccessKey: deployConfig.AwsAccessKeyId,
+		Passkey:   deployConfig.AwsSecretAccessKey,
+		Region:    deployConfig.AwsRegion,
 	}
-	imageIdsIdentifier := listImagesOutput.ImageIds
-	digestTagMap, err := impl.filterImages(imageIdsIdentifier, externalCiPipelineId)
+}
+
+// GetAwsClientFromCred creates an ECR client from static keys when they are given,
+// falling back to the default credential chain (env, shared config, IRSA) otherwise.
+func (impl *ReconciliationEcrServiceImpl) GetAwsClientFromCred(ctx context.Context, s3BaseConfig *AwsS3BaseConfig) (*ecr.Client, error) {
+	client, _, err := impl.getAwsClient(ctx, s3BaseConfig)
+	return client, err
+}
+
+// getAwsClient creates the ECR client like GetAwsClientFromCred and returns the region it
+// resolved, the one of the config or the one of the default chain.
+func (impl *ReconciliationEcrServiceImpl) getAwsClient(ctx context.Context, s3BaseConfig *AwsS3BaseConfig) (*ecr.Client, string, error) {
+	cfg, err := loadAwsConfig(ctx, s3BaseConfig)
 	if err != nil {
-		impl.logger.Errorw("error in filtering images", "err", err)
-		return err
+		impl.logger.Errorw("error in loading default config from aws ecr credentials", "err", err)
+		return nil, "", err
 	}
+	// Create ECR client from Config, its calls wait for the rate limit of the ECR api host of the region
+	svcClient := ecr.NewFromConfig(cfg, func(o *ecr.Options) {
+		o.HTTPClient = &http.Client{Transport: impl.registryRateLimiter.Transport(http.DefaultTransport.(*http.Transport).Clone())}
+		if s3BaseConfig.EndpointUrl != "" {
+			o.BaseEndpoint = aws.String(s3BaseConfig.EndpointUrl)
+		}
+	})
+
+	return svcClient, cfg.Region, nil
+}
+
+// loadAwsConfig loads the aws config from the static keys when they are given, from the
+// default credential chain otherwise.
+func loadAwsConfig(ctx context.Context, s3BaseConfig *AwsS3BaseConfig) (aws.Config, error) {
+	var optFns []func(*config.LoadOptions) error
+	if s3BaseConfig.AccessKey != "" {
+		optFns = append(optFns, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s3BaseConfig.AccessKey, s3BaseConfig.Passkey, "")))
+	}
+	if s3BaseConfig.Region != "" {
+		optFns = append(optFns, config.WithRegion(s3BaseConfig.Region))
+	}
+	return config.LoadDefaultConfig(ctx, optFns...)
+}
 
-	for digest, tag := range digestTagMap {
-		err = impl.commonService.CallExternalCIWebHook(digest, tag, hostUrl, repositoryName, externalCiPipelineId)
+// GetAllImagesList returns the details, including the push time, of every tagged
+// image of the repository, walking through all the pages of DescribeImages.
+func (impl *ReconciliationEcrServiceImpl) GetAllImagesList(ctx context.Context, client ecr.DescribeImagesAPIClient, registryId, repositoryName string) ([]types.ImageDetail, error) {
+	describeImagesInput := &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(repositoryName),
+		Filter:         &types.DescribeImagesFilter{TagStatus: types.TagStatusTagged},
+	}
+	if registryId != "" {
+		describeImagesInput.RegistryId = aws.String(registryId)
+	}
+	var imageDetails []types.ImageDetail
+	paginator := ecr.NewDescribeImagesPaginator(client, describeImagesInput)
+	for paginator.HasMorePages() {
+		describeImagesOutput, err := paginator.NextPage(ctx)
 		if err != nil {
-			impl.logger.Errorw("error in calling external ci webhook", "err", err, "digest", digest, "repoName", repositoryName, "externalCiId", externalCiPipelineId)
+			impl.logger.Errorw("error in describe images from ecr", "err", err, "repoName", repositoryName, "registryId", registryId)
+			return nil, impl.throttleOnThrottlingException(err)
 		}
+		imageDetails = append(imageDetails, describeImagesOutput.ImageDetails...)
 	}
+	return imageDetails, nil
+}
 
-	return nil
+// throttleOnThrottlingException backs off the ECR api host of the region when ECR throttled us,
+// ECR answering with a 400 and a ThrottlingException once the retries of the client are exhausted.
+func (impl *ReconciliationEcrServiceImpl) throttleOnThrottlingException(err error) error {
+	var apiErr smithy.APIError
+	var responseErr *smithyhttp.ResponseError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "ThrottlingException" ||
+		!errors.As(err, &responseErr) || responseErr.Response.Request == nil {
+		return err
+	}
+	return impl.registryRateLimiter.Throttle(responseErr.Response.Request.URL.Host, responseErr.HTTPStatusCode(), 0)
 }
 
 // /445808685819.dkr.ecr.us-east-2.amazonaws.com/devtron/html-ecr:cf50e450-125-588///Sample Image for reference
@@ -106,17 +219,50 @@ func getHostUrlForEcr(registryId, region string) string {
 	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", registryId, region)
 }
 
-func (impl *ReconciliationEcrServiceImpl) filterImages(imageIdsIdentifier []types.ImageIdentifier, externalCiPipelineId int) (map[string]string, error) {
-	digests := make([]string, 0, len(imageIdsIdentifier))
-	digestTagMap := make(map[string]string)
-	for i := 0; i < len(imageIdsIdentifier) && i < impl.config.ImageShowCount; i++ {
-		digests = append(digests, *imageIdsIdentifier[i].ImageDigest)
-		digestTagMap[*imageIdsIdentifier[i].ImageDigest] = *imageIdsIdentifier[i].ImageTag
+// filterImages filters and orders the images as configured for the source and
+// keeps the newest ImageShowCount of them in the report. ECR knows the push time of every image,
+// so it is used for all the time based orderings and when no order is configured.
+func (impl *ReconciliationEcrServiceImpl) filterImages(imageDetails []types.ImageDetail, deployConfig bean.DeployConfig, report *bean.ReconcileReport) error {
+	tagPolicy := deployConfig.TagPolicy
+	if tagOrder := tagPolicy.GetTagOrder(); tagOrder == bean.TagOrderRegistry || policy.IsTimeBased(tagOrder) {
+		tagPolicy.TagOrder = bean.TagOrderPushTime
 	}
-	err := impl.commonService.FilterAlreadyPresentArtifacts(digests, digestTagMap, externalCiPipelineId)
+	tagSelector, err := policy.NewTagSelector(tagPolicy)
 	if err != nil {
-		impl.logger.Errorw("error in filtering already present artifacts", "err", err, "digests", digests)
-		return nil, err
+		return err
+	}
+	tags := make([]string, 0, len(imageDetails))
+	tagDigestMap := make(map[string]string)
+	pushTimes := make(map[string]time.Time)
+	for _, imageDetail := range imageDetails {
+		if imageDetail.ImageDigest == nil {
+			continue
+		}
+		for _, tag := range imageDetail.ImageTags {
+			tags = append(tags, tag)
+			tagDigestMap[tag] = *imageDetail.ImageDigest
+			pushTimes[tag] = aws.ToTime(imageDetail.ImagePushedAt)
+		}
+	}
+	filteredTags := tagSelector.Filter(tags)
+	report.TagCount = len(filteredTags)
+	sortedTags, err := tagSelector.Sort(filteredTags, pushTimes)
+	if err != nil {
+		return err
+	}
+	for _, tag := range sortedTags {
+		if len(report.DiscoveredDigests) >= impl.config.ImageShowCount {
+			break
+		}
+		digest := tagDigestMap[tag]
+		if _, ok := report.DiscoveredDigests[digest]; ok {
+			continue
+		}
+		report.DiscoveredDigests[digest] = tag
+		if pushTime := pushTimes[tag]; !pushTime.IsZero() {
+			report.PushedOn[digest] = pushTime
+		}
+		report.Tags = append(report.Tags, tag)
 	}
-	return digestTagMap, err
+	return nil
 }
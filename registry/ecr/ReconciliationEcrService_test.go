@@ -0,0 +1,174 @@
+package ecr
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/registry"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubEcrClient answers DescribeImages with its pages, keyed by the next token of the request.
+type stubEcrClient struct {
+	pages  map[string]*ecr.DescribeImagesOutput
+	err    error
+	inputs []*ecr.DescribeImagesInput
+}
+
+func (c *stubEcrClient) DescribeImages(ctx context.Context, input *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	c.inputs = append(c.inputs, input)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.pages[aws.ToString(input.NextToken)], nil
+}
+
+// stubRateLimiter records the hosts it is asked to throttle.
+type stubRateLimiter struct {
+	throttledHosts []string
+}
+
+func (l *stubRateLimiter) Wait(ctx context.Context, host string) error {
+	return nil
+}
+
+func (l *stubRateLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	return base
+}
+
+func (l *stubRateLimiter) Throttle(host string, statusCode int, retryAfter time.Duration) *registry.ThrottledError {
+	l.throttledHosts = append(l.throttledHosts, host)
+	return &registry.ThrottledError{Host: host, StatusCode: statusCode, Until: time.Now().Add(time.Minute)}
+}
+
+func newTestReconciliationService(rateLimiter registry.RegistryRateLimiter) *ReconciliationEcrServiceImpl {
+	return &ReconciliationEcrServiceImpl{
+		logger:              zap.NewNop().Sugar(),
+		registryRateLimiter: rateLimiter,
+		config:              &ReconciliationConfig{ImageShowCount: 2},
+	}
+}
+
+func imageDetail(digest string, pushedAt time.Time, tags ...string) types.ImageDetail {
+	return types.ImageDetail{ImageDigest: aws.String(digest), ImagePushedAt: aws.Time(pushedAt), ImageTags: tags, RegistryId: aws.String("445808685819")}
+}
+
+func TestGetAllImagesList(t *testing.T) {
+	now := time.Now()
+	throttlingErr := &smithy.OperationError{ServiceID: "ECR", OperationName: "DescribeImages", Err: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Request:    &http.Request{URL: &url.URL{Scheme: "https", Host: "api.ecr.us-east-2.amazonaws.com"}},
+		}},
+		Err: &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"},
+	}}
+	tests := []struct {
+		name          string
+		client        *stubEcrClient
+		wantDigests   []string
+		wantThrottled bool
+	}{
+		{
+			name: "pages",
+			client: &stubEcrClient{pages: map[string]*ecr.DescribeImagesOutput{
+				"":      {ImageDetails: []types.ImageDetail{imageDetail("sha256:a", now, "1.0.0")}, NextToken: aws.String("page2")},
+				"page2": {ImageDetails: []types.ImageDetail{imageDetail("sha256:b", now, "1.1.0")}},
+			}},
+			wantDigests: []string{"sha256:a", "sha256:b"},
+		},
+		{
+			name:          "throttled",
+			client:        &stubEcrClient{err: throttlingErr},
+			wantThrottled: true,
+		},
+	}
+	for _, tt := range tests {
+		rateLimiter := &stubRateLimiter{}
+		impl := newTestReconciliationService(rateLimiter)
+		imageDetails, err := impl.GetAllImagesList(context.Background(), tt.client, "445808685819", "devtron/app")
+		if _, throttled := registry.AsThrottledError(err); throttled != tt.wantThrottled {
+			t.Fatalf("%s: GetAllImagesList() error = %v, want throttled %v", tt.name, err, tt.wantThrottled)
+		}
+		if tt.wantThrottled {
+			if !reflect.DeepEqual(rateLimiter.throttledHosts, []string{"api.ecr.us-east-2.amazonaws.com"}) {
+				t.Errorf("%s: throttled hosts = %v, want the ecr api host", tt.name, rateLimiter.throttledHosts)
+			}
+			continue
+		}
+		var digests []string
+		for _, detail := range imageDetails {
+			digests = append(digests, aws.ToString(detail.ImageDigest))
+		}
+		if !reflect.DeepEqual(digests, tt.wantDigests) {
+			t.Errorf("%s: GetAllImagesList() digests = %v, want %v", tt.name, digests, tt.wantDigests)
+		}
+		for _, input := range tt.client.inputs {
+			if aws.ToString(input.RegistryId) != "445808685819" || input.Filter.TagStatus != types.TagStatusTagged {
+				t.Errorf("%s: DescribeImages input = %+v, want the registry id and the tagged images", tt.name, input)
+			}
+		}
+	}
+}
+
+func TestFilterImages(t *testing.T) {
+	pushedAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	imageDetails := []types.ImageDetail{
+		imageDetail("sha256:a", pushedAt, "1.0.0", "stable"),
+		imageDetail("sha256:b", pushedAt.Add(time.Hour), "1.1.0"),
+		imageDetail("sha256:c", pushedAt.Add(2*time.Hour), "2.0.0-rc.1"),
+		{ImageTags: []string{"no-digest"}},
+	}
+	tests := []struct {
+		name      string
+		tagPolicy bean.TagPolicy
+		wantTags  []string
+		wantCount int
+	}{
+		{"push time by default", bean.TagPolicy{}, []string{"2.0.0-rc.1", "1.1.0"}, 4},
+		{"time based order", bean.TagPolicy{TagOrder: bean.TagOrderCreated}, []string{"2.0.0-rc.1", "1.1.0"}, 4},
+		{"semver range", bean.TagPolicy{SemverRange: ">=1.0.0"}, []string{"1.1.0", "1.0.0"}, 4},
+		{"exclude regex", bean.TagPolicy{TagExcludeRegex: `-rc`}, []string{"1.1.0", "1.0.0"}, 3},
+	}
+	for _, tt := range tests {
+		impl := newTestReconciliationService(&stubRateLimiter{})
+		deployConfig := bean.DeployConfig{ExternalCiId: 1, RepoName: "devtron/app", RegistryType: registry.REGISTRYTYPE_ECR, TagPolicy: tt.tagPolicy}
+		report := bean.NewReconcileReport(deployConfig)
+		if err := impl.filterImages(imageDetails, deployConfig, report); err != nil {
+			t.Fatalf("%s: filterImages() error = %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(report.Tags, tt.wantTags) || report.TagCount != tt.wantCount {
+			t.Errorf("%s: filterImages() tags = %v of %d, want %v of %d", tt.name, report.Tags, report.TagCount, tt.wantTags, tt.wantCount)
+		}
+		for digest := range report.DiscoveredDigests {
+			if report.PushedOn[digest].IsZero() {
+				t.Errorf("%s: filterImages() left the push time of %s unset", tt.name, digest)
+			}
+		}
+	}
+}
+
+func TestGetAwsClientResolvesRegion(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	impl := newTestReconciliationService(&stubRateLimiter{})
+	_, region, err := impl.getAwsClient(context.Background(), &AwsS3BaseConfig{})
+	if err != nil {
+		t.Fatalf("getAwsClient() error = %v", err)
+	}
+	if region != "eu-west-1" {
+		t.Errorf("getAwsClient() region = %q, want the region of the default chain", region)
+	}
+	if host := getHostUrlForEcr("445808685819", region); host != "445808685819.dkr.ecr.eu-west-1.amazonaws.com" {
+		t.Errorf("getHostUrlForEcr() = %q", host)
+	}
+}
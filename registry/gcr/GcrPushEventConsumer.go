@@ -0,0 +1,238 @@
+package gcr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/google/go-containerregistry/pkg/name"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2/google"
+	"io"
+	"net/http"
+	"time"
+)
+
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+
+// emptyPullBackoff spaces the pulls answered without messages, pubsub may answer them right
+// away instead of waiting for a message.
+const emptyPullBackoff = time.Second
+
+// PushEventHandler runs the images reported pushed by the registries through the
+// notification pipeline of their sources, it is implemented by the
+// SourceControllerService of the main package.
+type PushEventHandler interface {
+	HandlePushEvents(ctx context.Context, events []bean.PushEvent) ([]*bean.PushEventReport, error)
+}
+
+// GcrPushEventConsumer pulls the messages published by Container Registry and Artifact
+// Registry to the gcr topic of a project, through a subscription of the topic, and
+// notifies the pushed images to their sources. A message is acknowledged once its image
+// is settled, otherwise it is delivered again after the ack deadline of the subscription.
+type GcrPushEventConsumer interface {
+	Start()
+	Stop()
+}
+
+type GcrPushEventConsumerImpl struct {
+	logger           *zap.SugaredLogger
+	pushEventHandler PushEventHandler
+	config           *GcrPushEventConfig
+	cancel           context.CancelFunc
+	done             chan struct{}
+}
+
+type GcrPushEventConfig struct {
+	// Subscription is a pull subscription of the gcr topic, like projects/my-project/subscriptions/source-controller,
+	// the push events are not consumed when not set
+	Subscription string `env:"GCR_PUSH_EVENTS_SUBSCRIPTION" envDefault:""`
+	// EmulatorHost is the host of the Pub/Sub emulator, like localhost:8085, the requests to
+	// the emulator are neither encrypted nor authenticated. The application default
+	// credentials are used otherwise.
+	EmulatorHost         string `env:"PUBSUB_EMULATOR_HOST" envDefault:""`
+	MaxMessages          int    `env:"GCR_PUSH_EVENTS_MAX_MESSAGES" envDefault:"10"`
+	RetryIntervalSeconds int    `env:"GCR_PUSH_EVENTS_RETRY_INTERVAL_SECONDS" envDefault:"5"`
+}
+
+func NewGcrPushEventConsumerImpl(logger *zap.SugaredLogger,
+	pushEventHandler PushEventHandler) (*GcrPushEventConsumerImpl, error) {
+	cfg := &GcrPushEventConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		logger.Errorw("error in parsing gcr push event config", "err", err)
+		return nil, err
+	}
+	return &GcrPushEventConsumerImpl{
+		logger:           logger,
+		pushEventHandler: pushEventHandler,
+		config:           cfg,
+		done:             make(chan struct{}),
+	}, nil
+}
+
+type pullResponse struct {
+	ReceivedMessages []struct {
+		AckId   string `json:"ackId"`
+		Message struct {
+			Data        string    `json:"data"`
+			MessageId   string    `json:"messageId"`
+			PublishTime time.Time `json:"publishTime"`
+		} `json:"message"`
+	} `json:"receivedMessages"`
+}
+
+// gcrNotification is the data of the messages of the gcr topic, the digest and the tag
+// being the fully qualified names of the image, the tag missing for a push by digest.
+type gcrNotification struct {
+	Action string `json:"action"`
+	Digest string `json:"digest"`
+	Tag    string `json:"tag"`
+}
+
+// Start consumes the subscription in the background.
+func (impl *GcrPushEventConsumerImpl) Start() {
+	if impl.config.Subscription == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	client, endpoint := http.DefaultClient, "http://"+impl.config.EmulatorHost
+	if impl.config.EmulatorHost == "" {
+		var err error
+		client, err = google.DefaultClient(ctx, pubsubScope)
+		if err != nil {
+			impl.logger.Errorw("error in getting google credentials of gcr push event subscription", "err", err, "subscription", impl.config.Subscription)
+			cancel()
+			return
+		}
+		endpoint = "https://pubsub.googleapis.com"
+	}
+	impl.logger.Infow("consuming gcr push events", "subscription", impl.config.Subscription)
+	impl.cancel = cancel
+	go impl.consume(ctx, client, fmt.Sprintf("%s/v1/%s", endpoint, impl.config.Subscription))
+}
+
+func (impl *GcrPushEventConsumerImpl) consume(ctx context.Context, client *http.Client, subscriptionUrl string) {
+	defer close(impl.done)
+	for ctx.Err() == nil {
+		response := pullResponse{}
+		err := post(ctx, client, subscriptionUrl+":pull", map[string]interface{}{"maxMessages": impl.config.MaxMessages}, &response)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			impl.logger.Errorw("error in pulling gcr push events", "err", err, "subscription", impl.config.Subscription)
+			sleep(ctx, time.Duration(impl.config.RetryIntervalSeconds)*time.Second)
+			continue
+		}
+		if len(response.ReceivedMessages) == 0 {
+			sleep(ctx, emptyPullBackoff)
+			continue
+		}
+		var ackIds []string
+		for _, receivedMessage := range response.ReceivedMessages {
+			message := receivedMessage.Message
+			if impl.handleMessage(ctx, message.Data, message.PublishTime) {
+				ackIds = append(ackIds, receivedMessage.AckId)
+			}
+		}
+		if len(ackIds) == 0 {
+			continue
+		}
+		err = post(ctx, client, subscriptionUrl+":acknowledge", map[string]interface{}{"ackIds": ackIds}, nil)
+		if err != nil {
+			impl.logger.Errorw("error in acknowledging gcr push events", "err", err, "subscription", impl.config.Subscription)
+		}
+	}
+}
+
+// sleep waits for the duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// handleMessage notifies the image pushed by the message and tells whether the message is
+// done with: its image is settled, or it is not the push of a tagged image.
+func (impl *GcrPushEventConsumerImpl) handleMessage(ctx context.Context, data string, publishTime time.Time) bool {
+	notification := gcrNotification{}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err == nil {
+		err = json.Unmarshal(decoded, &notification)
+	}
+	if err != nil {
+		impl.logger.Errorw("error in decoding gcr push event, dropping it", "err", err, "data", data)
+		return true
+	}
+	pushEvent, ok := getPushEvent(notification, publishTime)
+	if !ok {
+		return true
+	}
+	reports, err := impl.pushEventHandler.HandlePushEvents(ctx, []bean.PushEvent{pushEvent})
+	if err != nil {
+		impl.logger.Errorw("error in handling gcr push event", "err", err, "event", pushEvent)
+		return false
+	}
+	return len(reports) == 1 && reports[0].Settled
+}
+
+// getPushEvent returns the push event of an INSERT notification of a tagged image.
+func getPushEvent(notification gcrNotification, publishTime time.Time) (bean.PushEvent, bool) {
+	if notification.Action != "INSERT" || notification.Tag == "" {
+		return bean.PushEvent{}, false
+	}
+	tag, err := name.NewTag(notification.Tag)
+	if err != nil {
+		return bean.PushEvent{}, false
+	}
+	pushEvent := bean.PushEvent{
+		Receiver:     "gcr",
+		RegistryHost: tag.RegistryStr(),
+		Repository:   tag.RepositoryStr(),
+		Tag:          tag.TagStr(),
+		PushedOn:     publishTime,
+	}
+	if digest, err := name.NewDigest(notification.Digest); err == nil {
+		pushEvent.Digest = digest.DigestStr()
+	}
+	return pushEvent, true
+}
+
+func post(ctx context.Context, client *http.Client, url string, body interface{}, response interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pubsub answered %d: %s", resp.StatusCode, respBody)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// Stop stops consuming the subscription, the messages being handled are delivered again later.
+func (impl *GcrPushEventConsumerImpl) Stop() {
+	if impl.cancel == nil {
+		return
+	}
+	impl.cancel()
+	<-impl.done
+}
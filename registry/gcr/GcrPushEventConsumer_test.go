@@ -0,0 +1,32 @@
+package gcr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetPushEvent(t *testing.T) {
+	publishTime := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	pushEvent, ok := getPushEvent(gcrNotification{
+		Action: "INSERT",
+		Digest: "us-east1-docker.pkg.dev/my-project/my-repo/hello@sha256:6ec128e26cd5a8a85d5cbd19d5ef9f72d5126c8b0f2b9f6a5ac4e5da9c3a8b71",
+		Tag:    "us-east1-docker.pkg.dev/my-project/my-repo/hello:1.1",
+	}, publishTime)
+	if !ok {
+		t.Fatal("getPushEvent() ignored the push of a tagged image")
+	}
+	if pushEvent.RegistryHost != "us-east1-docker.pkg.dev" || pushEvent.Repository != "my-project/my-repo/hello" || pushEvent.Tag != "1.1" {
+		t.Errorf("getPushEvent() = %+v, want us-east1-docker.pkg.dev my-project/my-repo/hello:1.1", pushEvent)
+	}
+	if pushEvent.Digest != "sha256:6ec128e26cd5a8a85d5cbd19d5ef9f72d5126c8b0f2b9f6a5ac4e5da9c3a8b71" || !pushEvent.PushedOn.Equal(publishTime) {
+		t.Errorf("getPushEvent() = %+v, want the digest and the publish time of the message", pushEvent)
+	}
+	for _, notification := range []gcrNotification{
+		{Action: "DELETE", Tag: "gcr.io/my-project/hello:1.1"},
+		{Action: "INSERT", Digest: "gcr.io/my-project/hello@sha256:6ec128e26cd5a8a85d5cbd19d5ef9f72d5126c8b0f2b9f6a5ac4e5da9c3a8b71"},
+	} {
+		if _, ok := getPushEvent(notification, publishTime); ok {
+			t.Errorf("getPushEvent(%+v) should be ignored", notification)
+		}
+	}
+}
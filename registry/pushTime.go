@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dockerHubApiUrl   = "https://hub.docker.com/v2"
+	dockerHubPageSize = 100
+)
+
+// GetRegistryType returns the configured registry type of the source, or the one
+// inferred from the registry host for the registries having a native push time.
+func GetRegistryType(deployConfig bean.DeployConfig) string {
+	if deployConfig.RegistryType != "" {
+		return deployConfig.RegistryType
+	}
+	host := strings.TrimSuffix(strings.Split(deployConfig.RegistryURL, "/")[0], "/")
+	switch {
+	case host == name.DefaultRegistry || host == "docker.io" || host == "registry-1.docker.io":
+		return REGISTRYTYPE_DOCKER_HUB
+	case host == "gcr.io" || strings.HasSuffix(host, ".gcr.io"):
+		return REGISTRYTYPE_GCR
+	case strings.HasSuffix(host, "-docker.pkg.dev"):
+		return REGISTRYTYPE_ARTIFACT_REGISTRY
+	}
+	return REGISTRYTYPE_OTHER
+}
+
+// SupportsPushTime tells whether the push time of the tags can be read from the
+// registry api; ECR sources get it from DescribeImages instead.
+func SupportsPushTime(registryType string) bool {
+	return registryType == REGISTRYTYPE_DOCKER_HUB || registryType == REGISTRYTYPE_GCR || registryType == REGISTRYTYPE_ARTIFACT_REGISTRY
+}
+
+// GetPushTimes returns the time at which each tag of the repository was last pushed.
+func GetPushTimes(ctx context.Context, registryType string, repo name.Repository, cred bean.RegistryCredential,
+	auth authn.Authenticator, t http.RoundTripper) (map[string]time.Time, error) {
+	switch registryType {
+	case REGISTRYTYPE_DOCKER_HUB:
+		return getDockerHubPushTimes(ctx, repo, cred, t)
+	case REGISTRYTYPE_GCR, REGISTRYTYPE_ARTIFACT_REGISTRY:
+		return getGooglePushTimes(ctx, repo, auth, t)
+	}
+	return nil, fmt.Errorf("push time ordering is not supported for registry type %q", registryType)
+}
+
+type googleManifestInfo struct {
+	Tags           []string `json:"tag"`
+	TimeUploadedMs string   `json:"timeUploadedMs"`
+}
+
+type googleTags struct {
+	Manifests map[string]googleManifestInfo `json:"manifest"`
+}
+
+// getGooglePushTimes reads the upload time of the tags from the manifest details
+// GCR and Artifact Registry add to the tags/list response.
+func getGooglePushTimes(ctx context.Context, repo name.Repository, auth authn.Authenticator, t http.RoundTripper) (map[string]time.Time, error) {
+	if auth == nil {
+		auth = authn.Anonymous
+	}
+	tr, err := transport.NewWithContext(ctx, repo.Registry, auth, t, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: tr}
+	uri := &url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/tags/list", repo.RepositoryStr()),
+	}
+	pushTimes := make(map[string]time.Time)
+	for uri != nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		tags := googleTags{}
+		err = transport.CheckError(resp, http.StatusOK)
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&tags)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, manifest := range tags.Manifests {
+			uploadedMs, err := strconv.ParseInt(manifest.TimeUploadedMs, 10, 64)
+			if err != nil {
+				continue
+			}
+			for _, tag := range manifest.Tags {
+				pushTimes[tag] = time.UnixMilli(uploadedMs)
+			}
+		}
+		uri, err = getNextPageURL(resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pushTimes, nil
+}
+
+// getNextPageURL follows the Link header of the docker registry api pagination.
+func getNextPageURL(resp *http.Response) (*url.URL, error) {
+	link := resp.Header.Get("Link")
+	if link == "" {
+		return nil, nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start || !strings.Contains(link, `rel="next"`) {
+		return nil, nil
+	}
+	next, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return nil, err
+	}
+	return resp.Request.URL.ResolveReference(next), nil
+}
+
+type dockerHubTag struct {
+	Name          string    `json:"name"`
+	TagLastPushed time.Time `json:"tag_last_pushed"`
+}
+
+type dockerHubTagList struct {
+	Next    string         `json:"next"`
+	Results []dockerHubTag `json:"results"`
+}
+
+// getDockerHubPushTimes reads the last push time of the tags from the Docker Hub
+// api, logging in first when the source has a username and password.
+func getDockerHubPushTimes(ctx context.Context, repo name.Repository, cred bean.RegistryCredential, t http.RoundTripper) (map[string]time.Time, error) {
+	client := &http.Client{Transport: t}
+	token := ""
+	if cred.Username != "" && cred.Password != "" {
+		var err error
+		token, err = loginToDockerHub(ctx, client, cred)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pushTimes := make(map[string]time.Time)
+	next := fmt.Sprintf("%s/repositories/%s/tags?page_size=%d", dockerHubApiUrl, repo.RepositoryStr(), dockerHubPageSize)
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		tagList := dockerHubTagList{}
+		err = transport.CheckError(resp, http.StatusOK)
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&tagList)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tagList.Results {
+			pushTimes[tag.Name] = tag.TagLastPushed
+		}
+		next = tagList.Next
+	}
+	return pushTimes, nil
+}
+
+func loginToDockerHub(ctx context.Context, client *http.Client, cred bean.RegistryCredential) (string, error) {
+	body, err := json.Marshal(map[string]string{"username": cred.Username, "password": cred.Password})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dockerHubApiUrl+"/users/login", bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err = transport.CheckError(resp, http.StatusOK); err != nil {
+		return "", err
+	}
+	login := struct {
+		Token string `json:"token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&login)
+	return login.Token, err
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/caarlos0/env"
+	"github.com/devtron-labs/source-controller/common"
 	"github.com/robfig/cron/v3"
 	"go.uber.org/zap"
 )
@@ -16,29 +17,63 @@ type SourceControllerCronServiceImpl struct {
 }
 
 type SourceControllerCronConfig struct {
-	SourceControllerCronTime int `env:"FETCH_LATEST_TAGS_CRON_TIME" envDefault:"5"`
+	// SchedulerTickSeconds is how often the sources due for reconciliation are looked for
+	SchedulerTickSeconds     int `env:"SOURCE_SCHEDULER_TICK_SECONDS" envDefault:"10"`
+	WebhookRetryIntervalSecs int `env:"WEBHOOK_RETRY_INTERVAL_SECONDS" envDefault:"30"`
 }
 
 func NewSourceControllerCronServiceImpl(logger *zap.SugaredLogger,
-	sourceControllerService SourceControllerService) (*SourceControllerCronServiceImpl, error) {
+	sourceControllerService SourceControllerService,
+	commonService common.CommonService) (*SourceControllerCronServiceImpl, error) {
 	sourceControllerServiceImpl := &SourceControllerCronServiceImpl{
 		logger:                  logger,
 		sourceControllerService: sourceControllerService,
 	}
-	// initialise cron
-	newCron := cron.New(cron.WithChain())
-	newCron.Start()
 	cfg := &SourceControllerCronConfig{}
 	err := env.Parse(cfg)
 	if err != nil {
 		logger.Errorw("failed to parse server cluster status config: ", "err", err.Error())
+		return sourceControllerServiceImpl, err
 	}
+	err = validateCronConfig(cfg)
+	if err != nil {
+		logger.Errorw("invalid source controller cron config", "err", err)
+		return sourceControllerServiceImpl, err
+	}
+	// initialise cron
+	newCron := cron.New(cron.WithChain())
+	newCron.Start()
 	// add function into cron
-	_, err = newCron.AddFunc(fmt.Sprintf("@every %dm", cfg.SourceControllerCronTime), sourceControllerService.ReconcileSourceWrapper)
+	// each source has its own schedule, the cron only starts the ones due
+	skipIfStillRunning := cron.NewChain(cron.SkipIfStillRunning(cron.DiscardLogger))
+	_, err = newCron.AddJob(fmt.Sprintf("@every %ds", cfg.SchedulerTickSeconds), skipIfStillRunning.Then(cron.FuncJob(sourceControllerService.ReconcileSourceWrapper)))
 	if err != nil {
 		logger.Errorw("error in adding cron function into SourceControllerCronService", "err", err)
 		return sourceControllerServiceImpl, err
 	}
+	// retry the webhook deliveries whose backoff has elapsed
+	_, err = newCron.AddFunc(fmt.Sprintf("@every %ds", cfg.WebhookRetryIntervalSecs), commonService.DeliverPendingImages)
+	if err != nil {
+		logger.Errorw("error in adding webhook retry function into SourceControllerCronService", "err", err)
+		return sourceControllerServiceImpl, err
+	}
 	return sourceControllerServiceImpl, nil
 
 }
+
+// validateCronConfig rejects the intervals which are not positive, cron would run the
+// jobs every second instead.
+func validateCronConfig(cfg *SourceControllerCronConfig) error {
+	for _, interval := range []struct {
+		name  string
+		value int
+	}{
+		{"SOURCE_SCHEDULER_TICK_SECONDS", cfg.SchedulerTickSeconds},
+		{"WEBHOOK_RETRY_INTERVAL_SECONDS", cfg.WebhookRetryIntervalSecs},
+	} {
+		if interval.value <= 0 {
+			return fmt.Errorf("%s: %d must be positive", interval.name, interval.value)
+		}
+	}
+	return nil
+}
@@ -1,57 +1,121 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/caarlos0/env"
 	"github.com/devtron-labs/source-controller/bean"
 	"github.com/devtron-labs/source-controller/common"
+	"github.com/devtron-labs/source-controller/config"
+	"github.com/devtron-labs/source-controller/internal/metrics"
+	"github.com/devtron-labs/source-controller/internal/util"
 	"github.com/devtron-labs/source-controller/oci"
+	"github.com/devtron-labs/source-controller/policy"
+	"github.com/devtron-labs/source-controller/registry"
+	"github.com/devtron-labs/source-controller/registry/ecr"
 	repository "github.com/devtron-labs/source-controller/sql/repo"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	gcrv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v2"
-	kuberecorder "k8s.io/client-go/tools/record"
 	"net/http"
-	"sigs.k8s.io/controller-runtime/pkg/client"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type SourceControllerService interface {
-	ReconcileSource(ctx context.Context, deployConfig DeployConfig) (bean.Result, error)
+	ReconcileSource(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error)
 	ReconcileSourceWrapper()
+	TriggerReconcile(ctx context.Context, request bean.ReconcileRequest) ([]*bean.ReconcileReport, error)
+	DryRunReconcile(ctx context.Context, request bean.ReconcileRequest, deployConfigs []bean.DeployConfig) ([]*bean.ReconcileReport, error)
+	ReloadDeployConfigs() error
+	WatchDeployConfigFile()
+	ReconcileSourceExclusively(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error)
+	OwnsSource(sourceKey string) bool
+	HandlePushEvents(ctx context.Context, events []bean.PushEvent) ([]*bean.PushEventReport, error)
 }
 
 type SourceControllerServiceImpl struct {
-	logger               *zap.SugaredLogger
-	SCSconfig            *SourceControllerConfig
-	ciArtifactRepository repository.CiArtifactRepository
-	commonService        common.CommonService
-	client.Client
-	kuberecorder.EventRecorder
+	logger                   *zap.SugaredLogger
+	SCSconfig                *SourceControllerConfig
+	ciArtifactRepository     repository.CiArtifactRepository
+	commonService            common.CommonService
+	reconciliationEcrService ecr.ReconciliationEcrService
+	sourceStatusService      common.SourceStatusService
+	externalCiSourceService  common.ExternalCiSourceService
+	registryRateLimiter      registry.RegistryRateLimiter
+	sourceShardService       common.SourceShardService
+	// sourceWorkers bounds the number of sources reconciled at the same time
+	sourceWorkers chan struct{}
+	// deployConfigLock guards SCSconfig.DeployConfigExternalCiObj, replaced when the config file is reloaded
+	deployConfigLock sync.RWMutex
+	scheduler        *sourceScheduler
+	createdTimes     *createdTimeCache
+	// ecrRegistries holds the registry id and region resolved for the ecr credentials, by
+	// ecrRegistryKey, for matching the push events of the sources leaving them empty
+	ecrRegistries sync.Map
+	// registeredSources is the set of sources last registered in the status table
+	registeredSources string
+	// sourceLocks holds a *sync.Mutex per source key, so that a source is never
+	// reconciled by the cron and an on demand trigger at the same time
+	sourceLocks sync.Map
 }
 
 type SourceControllerConfig struct {
-	ImageShowCount            int    `env:"IMAGE_COUNT_FROM_REPO" envDefault:"20"`
-	Insecure                  bool   `env:"INSECURE_EXTERNAL_CI" envDefault:"true"`
-	ApiToken                  string `env:"API_TOKEN_EXTERNAL_CI" envDefault:""`
-	ServiceName               string `env:"WEBHOOK_SERVICE_NAME" envDefault:"devtron-service"`
-	Namespace                 string `env:"WEBHOOK_NAMESPACE" envDefault:"devtroncd"`
-	DeployConfigExternalCi    string `env:"DEPLOY_CONFIG_EXTERNAL_CI"`
-	DeployConfigExternalCiObj []DeployConfig
+	ImageShowCount         int    `env:"IMAGE_COUNT_FROM_REPO" envDefault:"20"`
+	Insecure               bool   `env:"INSECURE_EXTERNAL_CI" envDefault:"true"`
+	ApiToken               string `env:"API_TOKEN_EXTERNAL_CI" envDefault:"" secretData:"-"`
+	ServiceName            string `env:"WEBHOOK_SERVICE_NAME" envDefault:"devtron-service"`
+	Namespace              string `env:"WEBHOOK_NAMESPACE" envDefault:"devtroncd"`
+	DeployConfigExternalCi string `env:"DEPLOY_CONFIG_EXTERNAL_CI" secretData:"-"`
+	// DeployConfigFilePath is a yaml or json file, usually a mounted config map, holding more
+	// sources in the format of DeployConfigExternalCi. It is reloaded when it changes and on SIGHUP.
+	DeployConfigFilePath string `env:"DEPLOY_CONFIG_FILE_PATH" envDefault:""`
+	// DeployConfigReloadIntervalSeconds is how often the config file is checked for changes,
+	// it is only reloaded on SIGHUP when not positive
+	DeployConfigReloadIntervalSeconds int `env:"DEPLOY_CONFIG_RELOAD_INTERVAL_SECONDS" envDefault:"30"`
+	DeployConfigExternalCiObj         []bean.DeployConfig
+	// SourcesFromDb adds a source for every external ci pipeline of the orchestrator,
+	// the sources of DeployConfigExternalCi override the ones of the same pipeline
+	SourcesFromDb bool `env:"EXTERNAL_CI_SOURCES_FROM_DB" envDefault:"false"`
+	// DefaultIntervalMinutes, DefaultTimeoutSeconds and DefaultJitterSeconds apply to the sources without their own schedule
+	DefaultIntervalMinutes int `env:"FETCH_LATEST_TAGS_CRON_TIME" envDefault:"5"`
+	DefaultTimeoutSeconds  int `env:"SOURCE_TIMEOUT_SECONDS" envDefault:"60"`
+	DefaultJitterSeconds   int `env:"SOURCE_JITTER_SECONDS" envDefault:"0"`
+	// SourceWorkerCount is the number of sources reconciled at the same time and
+	// DigestConcurrency the number of tags of a source whose digest is resolved at the same time
+	SourceWorkerCount int `env:"SOURCE_WORKER_COUNT" envDefault:"10"`
+	DigestConcurrency int `env:"DIGEST_RESOLUTION_CONCURRENCY" envDefault:"5"`
+	// DryRun makes every reconciliation plan its notifications instead of calling the webhook
+	DryRun bool `env:"DRY_RUN" envDefault:"false"`
 }
 
-type DeployConfig struct {
-	ExternalCiId int    `yaml:"EXTERNAL_CI_ID"`
-	RepoName     string `yaml:"REPO_NAME_EXTERNAL_CI"`
-	RegistryURL  string `yaml:"REGISTRY_URL_EXTERNAL_CI"`
+var UserAgent = "flux/v2"
+
+var errNoSourceMatched = &util.ApiError{
+	HttpStatusCode:  http.StatusNotFound,
+	Code:            "404",
+	UserMessage:     "no configured source matches the request",
+	InternalMessage: "no configured source matches the request",
 }
 
-var UserAgent = "flux/v2"
+var errNotOwner = &util.ApiError{
+	HttpStatusCode:  http.StatusServiceUnavailable,
+	Code:            "503",
+	UserMessage:     "the sources are reconciled by another replica, please retry",
+	InternalMessage: "replica owns none of the sources",
+}
 
 type invalidOCIURLError struct {
 	err error
@@ -63,11 +127,26 @@ func (e invalidOCIURLError) Error() string {
 
 func NewSourceControllerServiceImpl(logger *zap.SugaredLogger,
 	cfg *SourceControllerConfig,
-	ciArtifactRepository repository.CiArtifactRepository) *SourceControllerServiceImpl {
+	ciArtifactRepository repository.CiArtifactRepository,
+	commonService common.CommonService,
+	reconciliationEcrService ecr.ReconciliationEcrService,
+	sourceStatusService common.SourceStatusService,
+	externalCiSourceService common.ExternalCiSourceService,
+	registryRateLimiter registry.RegistryRateLimiter,
+	sourceShardService common.SourceShardService) *SourceControllerServiceImpl {
 	sourceControllerServiceImpl := &SourceControllerServiceImpl{
-		logger:               logger,
-		SCSconfig:            cfg,
-		ciArtifactRepository: ciArtifactRepository,
+		logger:                   logger,
+		SCSconfig:                cfg,
+		ciArtifactRepository:     ciArtifactRepository,
+		commonService:            commonService,
+		reconciliationEcrService: reconciliationEcrService,
+		sourceStatusService:      sourceStatusService,
+		externalCiSourceService:  externalCiSourceService,
+		registryRateLimiter:      registryRateLimiter,
+		sourceShardService:       sourceShardService,
+		sourceWorkers:            make(chan struct{}, atLeastOne(cfg.SourceWorkerCount)),
+		scheduler:                newSourceScheduler(),
+		createdTimes:             newCreatedTimeCache(createdTimeCacheSize),
 	}
 
 	return sourceControllerServiceImpl
@@ -80,7 +159,7 @@ func GetSourceControllerConfig() (*SourceControllerConfig, error) {
 		fmt.Println("failed to parse server cluster status config: " + err.Error())
 		return nil, err
 	}
-	deployConfig, err := UnmarshalDeployConfig(cfg.DeployConfigExternalCi)
+	deployConfig, err := loadDeployConfigs(cfg)
 	if err != nil {
 		fmt.Println("error in unmarshalling deploy config", "err", err)
 		return nil, err
@@ -89,6 +168,22 @@ func GetSourceControllerConfig() (*SourceControllerConfig, error) {
 	return cfg, err
 }
 
+// loadDeployConfigs returns the validated sources of DeployConfigExternalCi followed by the ones of the config file.
+func loadDeployConfigs(cfg *SourceControllerConfig) ([]bean.DeployConfig, error) {
+	deployConfig, err := UnmarshalDeployConfig(cfg.DeployConfigExternalCi)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DeployConfigFilePath != "" {
+		fileDeployConfig, err := config.LoadDeployConfigFile(cfg.DeployConfigFilePath)
+		if err != nil {
+			return nil, err
+		}
+		deployConfig = append(deployConfig, fileDeployConfig...)
+	}
+	return deployConfig, config.ValidateDeployConfigs(deployConfig)
+}
+
 // Have Kept For reference (can be used in future)
 //type CiCompleteEvent struct {
 //	CiProjectDetails   []pipeline.CiProjectDetails `json:"ciProjectDetails"`
@@ -106,75 +201,398 @@ func GetSourceControllerConfig() (*SourceControllerConfig, error) {
 //	FailureReason      string                      `json:"failureReason"`
 //}
 
+// ReconcileSourceWrapper is called on every tick of the cron, it starts the reconciliation
+// of the sources due, each with the timeout of its schedule, without waiting for them.
+// Only the sources owned by the replica are reconciled.
 func (impl *SourceControllerServiceImpl) ReconcileSourceWrapper() {
-	fmt.Println("cron started")
-	deployConfig := impl.SCSconfig.DeployConfigExternalCiObj
-	impl.logger.Infow("deploy config after unmarshalling yaml", "deployConfig", deployConfig)
+	deployConfig, err := impl.getDeployConfigs()
+	if err != nil {
+		impl.logger.Errorw("error in getting sources", "err", err)
+		return
+	}
 	if len(deployConfig) == 0 {
 		impl.logger.Errorw("error: no deploy config provided")
 		return
 	}
-	for i := 0; i < len(deployConfig); i++ {
-		result, err := impl.ReconcileSource(context.Background(), deployConfig[i])
+	ownedDeployConfigs := impl.getOwnedDeployConfigs(deployConfig)
+	metrics.OwnedSources.Set(float64(len(ownedDeployConfigs)))
+	if len(ownedDeployConfigs) == 0 {
+		// the sources are registered again once owning some, another replica may have changed them
+		impl.registeredSources = ""
+		return
+	}
+	impl.registerSources(deployConfig)
+	defaultJitter := time.Duration(impl.SCSconfig.DefaultJitterSeconds) * time.Second
+	for _, dueDeployConfig := range impl.scheduler.takeDue(ownedDeployConfigs, time.Now(), defaultJitter) {
+		go impl.reconcileScheduled(dueDeployConfig)
+	}
+}
+
+func (impl *SourceControllerServiceImpl) reconcileScheduled(deployConfig bean.DeployConfig) {
+	impl.sourceWorkers <- struct{}{}
+	defer func() { <-impl.sourceWorkers }()
+	report, err := impl.reconcileAndRecord(context.Background(), deployConfig)
+	if err != nil {
+		impl.logger.Errorw("error in reconciling sources", "err", err, "report", report)
+	}
+	// a throttled registry is not called again before the end of its backoff
+	var notBefore time.Time
+	if throttledErr, ok := registry.AsThrottledError(err); ok {
+		notBefore = throttledErr.Until
+	}
+	defaultInterval := time.Duration(impl.SCSconfig.DefaultIntervalMinutes) * time.Minute
+	defaultJitter := time.Duration(impl.SCSconfig.DefaultJitterSeconds) * time.Second
+	impl.scheduler.done(deployConfig, time.Now(), defaultInterval, defaultJitter, notBefore)
+}
+
+func (impl *SourceControllerServiceImpl) getOwnedDeployConfigs(deployConfigs []bean.DeployConfig) []bean.DeployConfig {
+	var ownedDeployConfigs []bean.DeployConfig
+	for _, deployConfig := range deployConfigs {
+		if impl.OwnsSource(deployConfig.GetSourceKey()) {
+			ownedDeployConfigs = append(ownedDeployConfigs, deployConfig)
+		}
+	}
+	return ownedDeployConfigs
+}
+
+// registerSources registers the sources in the status table whenever they change.
+func (impl *SourceControllerServiceImpl) registerSources(deployConfigs []bean.DeployConfig) {
+	sourceKeys := make([]string, 0, len(deployConfigs))
+	activeSourceKeys := make([]string, 0, len(deployConfigs))
+	for _, deployConfig := range deployConfigs {
+		sourceKeys = append(sourceKeys, fmt.Sprintf("%s/%t", deployConfig.GetSourceKey(), deployConfig.Suspend))
+		if !deployConfig.Suspend {
+			activeSourceKeys = append(activeSourceKeys, deployConfig.GetSourceKey())
+		}
+	}
+	registeredSources := strings.Join(sourceKeys, ",")
+	if registeredSources == impl.registeredSources {
+		return
+	}
+	metrics.ForgetSourcesExcept(activeSourceKeys)
+	impl.logger.Infow("sources changed", "deployConfig", util.ObfuscateSecretTags(deployConfigs))
+	err := impl.sourceStatusService.RegisterSources(deployConfigs)
+	if err != nil {
+		impl.logger.Errorw("error in registering sources", "err", err)
+		return
+	}
+	impl.registeredSources = registeredSources
+}
+
+// TriggerReconcile reconciles right away the sources selected by the request and
+// returns their reports, a source being reconciled by the cron is waited for.
+func (impl *SourceControllerServiceImpl) TriggerReconcile(ctx context.Context, request bean.ReconcileRequest) ([]*bean.ReconcileReport, error) {
+	deployConfigs, err := impl.getDeployConfigs()
+	if err != nil {
+		return nil, err
+	}
+	var matchedDeployConfigs []bean.DeployConfig
+	for _, deployConfig := range deployConfigs {
+		if request.Matches(deployConfig) {
+			matchedDeployConfigs = append(matchedDeployConfigs, deployConfig)
+		}
+	}
+	if len(matchedDeployConfigs) == 0 {
+		return nil, errNoSourceMatched
+	}
+	if len(impl.getOwnedDeployConfigs(matchedDeployConfigs)) == 0 {
+		return nil, errNotOwner
+	}
+	// the sources are reconciled by the workers shared with the cron, the ones owned
+	// by another replica are reported as such
+	reports := make([]*bean.ReconcileReport, len(matchedDeployConfigs))
+	var wg sync.WaitGroup
+	for i, deployConfig := range matchedDeployConfigs {
+		if !impl.OwnsSource(deployConfig.GetSourceKey()) {
+			reports[i] = bean.NewReconcileReport(deployConfig)
+			reports[i].Error = fmt.Sprintf("source is reconciled by replica %q", impl.sourceShardService.GetOwner(deployConfig.GetSourceKey()))
+			continue
+		}
+		wg.Add(1)
+		go func(i int, deployConfig bean.DeployConfig) {
+			defer wg.Done()
+			impl.sourceWorkers <- struct{}{}
+			defer func() { <-impl.sourceWorkers }()
+			report, err := impl.reconcileAndRecord(ctx, deployConfig)
+			if err != nil {
+				impl.logger.Errorw("error in reconciling source on demand", "err", err, "sourceKey", deployConfig.GetSourceKey())
+				report.Error = err.Error()
+			}
+			reports[i] = report
+		}(i, deployConfig)
+	}
+	wg.Wait()
+	return reports, nil
+}
+
+// DryRunReconcile runs the reconciliation of the given sources, or of the configured
+// sources selected by the request when none is given, without calling the webhook and
+// returns the planned notifications. The status of the sources is left untouched.
+func (impl *SourceControllerServiceImpl) DryRunReconcile(ctx context.Context, request bean.ReconcileRequest, deployConfigs []bean.DeployConfig) ([]*bean.ReconcileReport, error) {
+	if len(deployConfigs) == 0 {
+		configuredDeployConfigs, err := impl.getDeployConfigs()
+		if err != nil {
+			return nil, err
+		}
+		for _, deployConfig := range configuredDeployConfigs {
+			if request.Matches(deployConfig) {
+				deployConfigs = append(deployConfigs, deployConfig)
+			}
+		}
+	}
+	if len(deployConfigs) == 0 {
+		return nil, errNoSourceMatched
+	}
+	ctx = bean.ContextWithDryRun(ctx)
+	reports := make([]*bean.ReconcileReport, 0, len(deployConfigs))
+	for _, deployConfig := range deployConfigs {
+		timeoutCtx, cancel := impl.withTimeout(ctx, deployConfig)
+		report, err := impl.ReconcileSource(timeoutCtx, deployConfig)
+		cancel()
 		if err != nil {
-			impl.logger.Errorw("error in reconciling sources", "err", err, "result", result)
+			impl.logger.Errorw("error in dry run of source", "err", err, "sourceKey", deployConfig.GetSourceKey())
+			report.Error = err.Error()
+		}
+		report.DryRun = true
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
 
+// getDeployConfigs returns the sources to reconcile: the ones of DeployConfigExternalCi
+// and, when SourcesFromDb is set, the external ci pipelines of the orchestrator read
+// afresh, so that the pipelines created since the last cycle are picked up.
+func (impl *SourceControllerServiceImpl) getDeployConfigs() ([]bean.DeployConfig, error) {
+	impl.deployConfigLock.RLock()
+	deployConfigs := impl.SCSconfig.DeployConfigExternalCiObj
+	impl.deployConfigLock.RUnlock()
+	if !impl.SCSconfig.SourcesFromDb {
+		return deployConfigs, nil
+	}
+	dbDeployConfigs, err := impl.externalCiSourceService.GetDeployConfigs()
+	if err != nil {
+		impl.logger.Errorw("error in getting sources from orchestrator", "err", err)
+		return nil, err
+	}
+	configuredExternalCiIds := make(map[int]bool, len(deployConfigs))
+	for _, deployConfig := range deployConfigs {
+		configuredExternalCiIds[deployConfig.ExternalCiId] = true
+	}
+	mergedDeployConfigs := make([]bean.DeployConfig, 0, len(deployConfigs)+len(dbDeployConfigs))
+	mergedDeployConfigs = append(mergedDeployConfigs, deployConfigs...)
+	for _, dbDeployConfig := range dbDeployConfigs {
+		if !configuredExternalCiIds[dbDeployConfig.ExternalCiId] {
+			mergedDeployConfigs = append(mergedDeployConfigs, dbDeployConfig)
 		}
 	}
-	fmt.Println("cron ended")
+	return mergedDeployConfigs, nil
+}
+
+// ReloadDeployConfigs reads the configured sources again, they are only replaced when all of them are valid.
+func (impl *SourceControllerServiceImpl) ReloadDeployConfigs() error {
+	deployConfigs, err := loadDeployConfigs(impl.SCSconfig)
+	if err != nil {
+		return err
+	}
+	impl.deployConfigLock.Lock()
+	impl.SCSconfig.DeployConfigExternalCiObj = deployConfigs
+	impl.deployConfigLock.Unlock()
+	impl.logger.Infow("deploy config reloaded", "sources", len(deployConfigs))
+	return nil
+}
+
+// WatchDeployConfigFile reloads the sources whenever the config file changes or on SIGHUP.
+// Without a config file there is nothing to reload, SIGHUP is then ignored rather than
+// terminating the process.
+func (impl *SourceControllerServiceImpl) WatchDeployConfigFile() {
+	if impl.SCSconfig.DeployConfigFilePath == "" {
+		signal.Ignore(syscall.SIGHUP)
+		return
+	}
+	interval := time.Duration(impl.SCSconfig.DeployConfigReloadIntervalSeconds) * time.Second
+	config.NewDeployConfigWatcher(impl.logger, impl.SCSconfig.DeployConfigFilePath, interval, impl.ReloadDeployConfigs).Start()
+}
+
+func (impl *SourceControllerServiceImpl) OwnsSource(sourceKey string) bool {
+	return impl.sourceShardService.OwnsSource(sourceKey)
+}
+
+// withTimeout bounds the context to the timeout of the schedule of the source, it
+// reaches the registry calls through makeRemoteOptions and the aws client.
+func (impl *SourceControllerServiceImpl) withTimeout(ctx context.Context, deployConfig bean.DeployConfig) (context.Context, context.CancelFunc) {
+	defaultTimeout := time.Duration(impl.SCSconfig.DefaultTimeoutSeconds) * time.Second
+	return context.WithTimeout(ctx, deployConfig.GetTimeout(defaultTimeout))
+}
+
+// ReconcileSourceExclusively reconciles the source holding its lock, so that a source
+// is never reconciled twice at the same time.
+func (impl *SourceControllerServiceImpl) ReconcileSourceExclusively(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error) {
+	unlock := impl.lockSource(deployConfig.GetSourceKey())
+	defer unlock()
+	if impl.SCSconfig.DryRun {
+		ctx = bean.ContextWithDryRun(ctx)
+	}
+	startedOn := time.Now()
+	report, err := impl.ReconcileSource(ctx, deployConfig)
+	recordReconcileMetrics(deployConfig, time.Since(startedOn), report, err)
+	return report, err
+}
+
+// lockSource takes the lock of the source and returns the function releasing it.
+func (impl *SourceControllerServiceImpl) lockSource(sourceKey string) func() {
+	lock, _ := impl.sourceLocks.LoadOrStore(sourceKey, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+func recordReconcileMetrics(deployConfig bean.DeployConfig, duration time.Duration, report *bean.ReconcileReport, err error) {
+	registryHost := deployConfig.RegistryURL
+	if registryHost == "" {
+		registryHost = deployConfig.RegistryType
+	}
+	var reason string
+	if _, ok := registry.AsThrottledError(err); ok {
+		reason = metrics.ReasonThrottled
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		reason = metrics.ReasonTimeout
+	} else if err != nil {
+		reason = metrics.ReasonError
+	}
+	var tagCount, notifiedCount int
+	if report != nil {
+		tagCount, notifiedCount = report.TagCount, len(report.NotifiedDigests)
+	}
+	metrics.RecordReconcile(deployConfig.GetSourceKey(), registryHost, duration, reason, tagCount, notifiedCount)
+}
+
+// reconcileAndRecord reconciles the source exclusively, within the timeout of its
+// schedule, and records the outcome in its status.
+func (impl *SourceControllerServiceImpl) reconcileAndRecord(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error) {
+	ctx, cancel := impl.withTimeout(ctx, deployConfig)
+	defer cancel()
+	startedOn := time.Now()
+	report, err := impl.ReconcileSourceExclusively(ctx, deployConfig)
+	impl.sourceStatusService.RecordReconcile(deployConfig, startedOn, report, err)
+	return report, err
 }
 
-func (impl *SourceControllerServiceImpl) ReconcileSource(ctx context.Context, deployConfig DeployConfig) (bean.Result, error) {
-	var auth authn.Authenticator
-	keychain := oci.Anonymous{}
-	transport := remote.DefaultTransport.(*http.Transport).Clone()
+func (impl *SourceControllerServiceImpl) ReconcileSource(ctx context.Context, deployConfig bean.DeployConfig) (*bean.ReconcileReport, error) {
+	if deployConfig.RegistryType == registry.REGISTRYTYPE_ECR {
+		return impl.reconciliationEcrService.ReconcileSource(ctx, deployConfig)
+	}
+	report := bean.NewReconcileReport(deployConfig)
+	keychain, auth, err := oci.GetKeychainAndAuth(deployConfig.RegistryCredential)
+	if err != nil {
+		impl.logger.Errorw("error in getting registry credentials", "err", err, "repoName", deployConfig.RepoName, "externalCiId", deployConfig.ExternalCiId)
+		return report, err
+	}
+	// every call to the registry, including the ones of the push times, waits for the limit of its host
+	transport := impl.registryRateLimiter.Transport(remote.DefaultTransport.(*http.Transport).Clone())
 	opts := makeRemoteOptions(ctx, transport, keychain, auth, impl.SCSconfig.Insecure)
 
 	url, err := parseRepositoryURLInValidFormat(deployConfig.RegistryURL, deployConfig.RepoName)
 	if err != nil {
 		impl.logger.Errorw("error in parsing repository url in valid format", "err", err)
-		return bean.ResultEmpty, invalidOCIURLError{err}
+		return report, invalidOCIURLError{err}
+	}
+	tagSelector, err := policy.NewTagSelector(deployConfig.TagPolicy)
+	if err != nil {
+		impl.logger.Errorw("error in tag policy of source", "err", err, "url", url, "tagPolicy", deployConfig.TagPolicy)
+		return report, err
 	}
 	tags, err := getAllTags(url, opts.craneOpts)
 	if err != nil {
 		impl.logger.Errorw("error in getting all tags ", "err", err, "url", url)
-		return bean.ResultEmpty, err
+		return report, err
 	}
-	digests := make([]string, 0, len(tags))
-	digestTagMap := make(map[string]string)
-	for i := 0; i < len(tags) && i < impl.SCSconfig.ImageShowCount; i++ {
-		tag := tags[i]
-		// Determine which artifact revision to pull
-		tagUrl, err := getArtifactURLForTag(url, tag)
-		if err != nil {
-			impl.logger.Errorw("error in getting artifact url", "err", err, "tag", tag)
-			return bean.ResultEmpty, err
+	// filter first so that excluded tags are neither inspected nor resolved
+	tags = tagSelector.Filter(tags)
+	report.TagCount = len(tags)
+	tags, times, tagDigests, err := impl.sortTags(ctx, url, tags, tagSelector, deployConfig, keychain, auth, transport, opts)
+	if err != nil {
+		impl.logger.Errorw("error in ordering tags", "err", err, "url", url, "tagPolicy", deployConfig.TagPolicy)
+		return report, err
+	}
+	if len(tags) > impl.SCSconfig.ImageShowCount {
+		tags = tags[:impl.SCSconfig.ImageShowCount]
+	}
+	var digests []string
+	if tagDigests != nil {
+		digests = make([]string, len(tags))
+		for i, tag := range tags {
+			digests[i] = tagDigests[tag]
 		}
-		digest, err := crane.Digest(tagUrl, opts.craneOpts...)
+	} else {
+		digests, err = impl.resolveDigests(url, tags, opts)
 		if err != nil {
-			fmt.Errorf("error")
-
+			return report, err
+		}
+	}
+	for i, tag := range tags {
+		if digests[i] == "" {
+			continue
+		}
+		report.Tags = append(report.Tags, tag)
+		report.DiscoveredDigests[digests[i]] = tag
+		if pushTime, ok := times[tag]; ok {
+			report.PushedOn[digests[i]] = pushTime
 		}
-		digestTagMap[digest] = tag
-		digests = append(digests, digest)
 	}
+	impl.resolveCreatedTimes(url, deployConfig, report, opts)
 
-	err = impl.commonService.FilterAlreadyPresentArtifacts(digests, digestTagMap, deployConfig.ExternalCiId)
+	err = impl.commonService.ReportDiscoveredImages(ctx, deployConfig, deployConfig.RegistryURL, report)
 	if err != nil {
-		impl.logger.Errorw("error in filtering artifacts", "err", err)
-		return bean.ResultEmpty, err
+		impl.logger.Errorw("error in notifying discovered images", "err", err, "repoName", deployConfig.RepoName, "externalCiId", deployConfig.ExternalCiId)
+		return report, err
 	}
-	for digest, tag := range digestTagMap {
-		err = impl.commonService.CallExternalCIWebHook(digest, tag, deployConfig.RegistryURL, deployConfig.RepoName, deployConfig.ExternalCiId)
+	return report, nil
+}
+
+// resolveDigests resolves the digests of the tags, DigestConcurrency at a time, in the
+// order of the tags. The digest of a tag which cannot be resolved is left empty, unless
+// the registry throttled us, the reconciliation then fails like for the listing of the tags.
+func (impl *SourceControllerServiceImpl) resolveDigests(url string, tags []string, opts remoteOptions) ([]string, error) {
+	digests := make([]string, len(tags))
+	var group errgroup.Group
+	group.SetLimit(atLeastOne(impl.SCSconfig.DigestConcurrency))
+	for i, tag := range tags {
+		// Determine which artifact revision to pull
+		tagUrl, err := getArtifactURLForTag(url, tag)
 		if err != nil {
-			impl.logger.Errorw("error in calling external ci webhook", "err", err, "digest", digest, "repoName", deployConfig.RepoName, "externalCiId", deployConfig.ExternalCiId)
+			impl.logger.Errorw("error in getting artifact url", "err", err, "tag", tag)
+			_ = group.Wait()
+			return nil, err
 		}
+		i := i
+		group.Go(func() error {
+			digest, err := crane.Digest(tagUrl, opts.craneOpts...)
+			if err != nil {
+				impl.logger.Errorw("error in getting digest for tag", "err", err, "tagUrl", tagUrl)
+				if _, ok := registry.AsThrottledError(err); ok {
+					return err
+				}
+				return nil
+			}
+			digests[i] = digest
+			return nil
+		})
+	}
+	// the tags which failed otherwise are only logged
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
 	}
-	return bean.ResultSuccess, err
+	return n
 }
 
-func UnmarshalDeployConfig(data string) ([]DeployConfig, error) {
-	var deployConfig []DeployConfig
+func UnmarshalDeployConfig(data string) ([]bean.DeployConfig, error) {
+	var deployConfig []bean.DeployConfig
 	err := yaml.Unmarshal([]byte(data), &deployConfig)
 	if err != nil {
 		return nil, err
@@ -191,6 +609,202 @@ func getAllTags(url string, options []crane.Option) ([]string, error) {
 	return tags, nil
 }
 
+// sortTags orders the tags as configured for the source, newest first. For the
+// time based orderings the creation or push time of every tag is fetched from
+// the registry, and returned, tags whose time cannot be determined are left out.
+// For the created and annotation orderings the digests of the tags are resolved
+// first, so that only the images not seen before are inspected, and returned.
+func (impl *SourceControllerServiceImpl) sortTags(ctx context.Context, url string, tags []string, tagSelector *policy.TagSelector, deployConfig bean.DeployConfig,
+	keychain authn.Keychain, auth authn.Authenticator, transport http.RoundTripper, opts remoteOptions) ([]string, map[string]time.Time, map[string]string, error) {
+	var times map[string]time.Time
+	var tagDigests map[string]string
+	var err error
+	tagOrder := deployConfig.GetTagOrder()
+	switch tagOrder {
+	case bean.TagOrderPushTime:
+		times, err = getPushTimes(ctx, url, deployConfig, keychain, auth, transport)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	case bean.TagOrderCreated, bean.TagOrderAnnotation:
+		digests, err := impl.resolveDigests(url, tags, opts)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		createdTimes, err := impl.getDigestCreatedTimes(url, digests, tagOrder, opts)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		times = make(map[string]time.Time, len(tags))
+		tagDigests = make(map[string]string, len(tags))
+		for i, tag := range tags {
+			tagDigests[tag] = digests[i]
+			if createdTime, ok := createdTimes[digests[i]]; ok {
+				times[tag] = createdTime
+			}
+		}
+	}
+	sortedTags, err := tagSelector.Sort(tags, times)
+	return sortedTags, times, tagDigests, err
+}
+
+// getDigestCreatedTimes returns the creation time of the digests of the repository, only the
+// digests missing from the cache being inspected. The digests which cannot be inspected are
+// left out, unless the registry throttled us, the error is then returned.
+func (impl *SourceControllerServiceImpl) getDigestCreatedTimes(url string, digests []string, tagOrder string, opts remoteOptions) (map[string]time.Time, error) {
+	annotation := tagOrder == bean.TagOrderAnnotation
+	createdTimes := make(map[string]time.Time, len(digests))
+	var unknownDigests, digestUrls []string
+	for _, digest := range digests {
+		if digest == "" {
+			continue
+		}
+		if _, ok := createdTimes[digest]; ok {
+			continue
+		}
+		if createdTime, ok := impl.createdTimes.get(createdTimeKey(url, digest, annotation)); ok {
+			createdTimes[digest] = createdTime
+			continue
+		}
+		// marks the digest seen, it is removed below if it cannot be inspected
+		createdTimes[digest] = time.Time{}
+		unknownDigests = append(unknownDigests, digest)
+		digestUrls = append(digestUrls, fmt.Sprintf("%s@%s", url, digest))
+	}
+	fetchedTimes, err := impl.getCreatedTimes(digestUrls, tagOrder, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, digest := range unknownDigests {
+		if fetchedTimes[i].IsZero() {
+			delete(createdTimes, digest)
+			continue
+		}
+		createdTimes[digest] = fetchedTimes[i]
+		impl.createdTimes.add(createdTimeKey(url, digest, annotation), fetchedTimes[i])
+	}
+	return createdTimes, nil
+}
+
+// resolveCreatedTimes fetches the creation time of the discovered digests whose time is not
+// known from the ordering of the tags, only for the digests new to the source so that the
+// images are not inspected on every reconciliation. The time is used for the push to trigger
+// latency, a digest whose time cannot be fetched is left without.
+func (impl *SourceControllerServiceImpl) resolveCreatedTimes(url string, deployConfig bean.DeployConfig, report *bean.ReconcileReport, opts remoteOptions) {
+	var digests []string
+	for digest := range report.DiscoveredDigests {
+		if _, ok := report.PushedOn[digest]; !ok {
+			digests = append(digests, digest)
+		}
+	}
+	if len(digests) == 0 {
+		return
+	}
+	unknownDigests, err := impl.commonService.GetUnknownDigests(deployConfig, digests)
+	if err != nil {
+		return
+	}
+	// the times are only measured, a throttled registry leaves the digests without
+	createdTimes, err := impl.getDigestCreatedTimes(url, unknownDigests, deployConfig.GetTagOrder(), opts)
+	if err != nil {
+		impl.logger.Warnw("error in getting created time of digests", "err", err, "url", url)
+	}
+	for digest, createdTime := range createdTimes {
+		report.PushedOn[digest] = createdTime
+	}
+}
+
+// getCreatedTimes fetches the creation time of the images, from the annotation for the
+// annotation order and from the config otherwise, DigestConcurrency at a time and in the
+// order of the urls. The time of an image which cannot be inspected is left zero, unless
+// the registry throttled us, the error is then returned.
+func (impl *SourceControllerServiceImpl) getCreatedTimes(imageUrls []string, tagOrder string, opts remoteOptions) ([]time.Time, error) {
+	createdTimes := make([]time.Time, len(imageUrls))
+	var group errgroup.Group
+	group.SetLimit(atLeastOne(impl.SCSconfig.DigestConcurrency))
+	for i, imageUrl := range imageUrls {
+		i, imageUrl := i, imageUrl
+		group.Go(func() error {
+			var createdTime time.Time
+			var err error
+			if tagOrder == bean.TagOrderAnnotation {
+				createdTime, err = getAnnotationCreatedTime(imageUrl, opts.craneOpts)
+			} else {
+				createdTime, err = getConfigCreatedTime(imageUrl, opts.craneOpts)
+			}
+			if err != nil {
+				if _, ok := registry.AsThrottledError(err); ok {
+					return err
+				}
+				impl.logger.Warnw("error in getting created time of image, skipping it", "err", err, "imageUrl", imageUrl)
+				return nil
+			}
+			createdTimes[i] = createdTime
+			return nil
+		})
+	}
+	return createdTimes, group.Wait()
+}
+
+// getPushTimes returns the push time of the tags of the repository as reported by the registry api.
+func getPushTimes(ctx context.Context, url string, deployConfig bean.DeployConfig, keychain authn.Keychain, auth authn.Authenticator, transport http.RoundTripper) (map[string]time.Time, error) {
+	repo, err := name.NewRepository(url)
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil {
+		auth, err = keychain.Resolve(repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return registry.GetPushTimes(ctx, registry.GetRegistryType(deployConfig), repo, deployConfig.RegistryCredential, auth, transport)
+}
+
+// getConfigCreatedTime returns the created time of the image config.
+func getConfigCreatedTime(tagUrl string, options []crane.Option) (time.Time, error) {
+	rawConfig, err := crane.Config(tagUrl, options...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	configFile, err := gcrv1.ParseConfigFile(bytes.NewReader(rawConfig))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return configFile.Created.Time, nil
+}
+
+// getAnnotationCreatedTime returns the org.opencontainers.image.created annotation of
+// the manifest, falling back to the label of the same name of the image config.
+func getAnnotationCreatedTime(tagUrl string, options []crane.Option) (time.Time, error) {
+	rawManifest, err := crane.Manifest(tagUrl, options...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	manifest := struct {
+		Annotations map[string]string `json:"annotations"`
+	}{}
+	if err = json.Unmarshal(rawManifest, &manifest); err != nil {
+		return time.Time{}, err
+	}
+	created, ok := manifest.Annotations[ocispec.AnnotationCreated]
+	if !ok {
+		rawConfig, err := crane.Config(tagUrl, options...)
+		if err != nil {
+			return time.Time{}, err
+		}
+		configFile, err := gcrv1.ParseConfigFile(bytes.NewReader(rawConfig))
+		if err != nil {
+			return time.Time{}, err
+		}
+		created, ok = configFile.Config.Labels[ocispec.AnnotationCreated]
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s annotation not found", ocispec.AnnotationCreated)
+	}
+	return time.Parse(time.RFC3339, created)
+}
+
 // getArtifactURL determines which tag or revision should be used and returns the OCI artifact FQN.
 func getArtifactURLForTag(tag, url string) (string, error) {
 	if tag != "" {
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/internal/metrics"
+	"github.com/devtron-labs/source-controller/oci"
+	"github.com/devtron-labs/source-controller/policy"
+	"github.com/devtron-labs/source-controller/registry"
+	repository "github.com/devtron-labs/source-controller/sql/repo"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"net/http"
+)
+
+// HandlePushEvents runs the images reported pushed by a registry through the tag policy,
+// the discovery ledger and the webhook of the sources of their repository, without
+// waiting for the next poll. The replica owning a source delivers its images right away,
+// the other replicas only record them in the ledger, the owner delivering them with its
+// pending images.
+func (impl *SourceControllerServiceImpl) HandlePushEvents(ctx context.Context, events []bean.PushEvent) ([]*bean.PushEventReport, error) {
+	deployConfigs, err := impl.getDeployConfigs()
+	if err != nil {
+		return nil, err
+	}
+	deployConfigs = impl.resolveEcrRegistries(ctx, deployConfigs)
+	eventReports := make([]*bean.PushEventReport, 0, len(events))
+	for _, event := range events {
+		eventReport := &bean.PushEventReport{Event: event, Reports: []*bean.ReconcileReport{}, Settled: true}
+		result := "unmatched"
+		for _, deployConfig := range deployConfigs {
+			// a suspended source is neither polled nor notified of its pushes
+			if deployConfig.Suspend || !deployConfig.MatchesRepository(event.RegistryHost, event.Repository) {
+				continue
+			}
+			if result == "unmatched" {
+				result = "matched"
+			}
+			report, settled, err := impl.handlePushEvent(ctx, deployConfig, event)
+			if err != nil {
+				impl.logger.Errorw("error in handling push event", "err", err, "sourceKey", deployConfig.GetSourceKey(), "event", event)
+				report.Error = err.Error()
+				result = "error"
+			}
+			eventReport.Reports = append(eventReport.Reports, report)
+			eventReport.Settled = eventReport.Settled && settled
+		}
+		metrics.RecordPushEvent(event.Receiver, result)
+		eventReports = append(eventReports, eventReport)
+	}
+	return eventReports, nil
+}
+
+// ecrRegistry is the registry id and region of the ecr registry of some credentials.
+type ecrRegistry struct {
+	registryId string
+	region     string
+}
+
+func ecrRegistryKey(deployConfig bean.DeployConfig) string {
+	return deployConfig.AwsAccessKeyId + "/" + deployConfig.AwsRegion + "/" + deployConfig.AwsRegistryId
+}
+
+// resolveEcrRegistries fills in the registry id and the region of the ecr sources leaving them
+// empty, so that their push events match the registry of their credentials only. A source whose
+// registry cannot be resolved matches no push event, its images being discovered by polling.
+func (impl *SourceControllerServiceImpl) resolveEcrRegistries(ctx context.Context, deployConfigs []bean.DeployConfig) []bean.DeployConfig {
+	resolved := make([]bean.DeployConfig, 0, len(deployConfigs))
+	for _, deployConfig := range deployConfigs {
+		if deployConfig.RegistryType != registry.REGISTRYTYPE_ECR || deployConfig.RegistryURL != "" ||
+			(deployConfig.AwsRegistryId != "" && deployConfig.AwsRegion != "") {
+			resolved = append(resolved, deployConfig)
+			continue
+		}
+		key := ecrRegistryKey(deployConfig)
+		cached, ok := impl.ecrRegistries.Load(key)
+		if !ok {
+			registryId, region, err := impl.reconciliationEcrService.ResolveRegistry(ctx, deployConfig)
+			if err != nil {
+				impl.logger.Warnw("error in resolving ecr registry of source, its push events are not matched", "err", err, "sourceKey", deployConfig.GetSourceKey())
+				resolved = append(resolved, deployConfig)
+				continue
+			}
+			cached, _ = impl.ecrRegistries.LoadOrStore(key, ecrRegistry{registryId: registryId, region: region})
+		}
+		deployConfig.AwsRegistryId = cached.(ecrRegistry).registryId
+		deployConfig.AwsRegion = cached.(ecrRegistry).region
+		resolved = append(resolved, deployConfig)
+	}
+	return resolved
+}
+
+// handlePushEvent notifies, or records, the pushed image for the source when its tag is
+// selected by the tag policy, as a polled tag would be, and tells whether the image is
+// settled for the source.
+func (impl *SourceControllerServiceImpl) handlePushEvent(ctx context.Context, deployConfig bean.DeployConfig, event bean.PushEvent) (*bean.ReconcileReport, bool, error) {
+	report := bean.NewReconcileReport(deployConfig)
+	tagSelector, err := policy.NewTagSelector(deployConfig.TagPolicy)
+	if err != nil {
+		return report, false, err
+	}
+	if event.Tag == "" || !tagSelector.Matches(event.Tag) {
+		return report, true, nil
+	}
+	report.TagCount = 1
+	digest := event.Digest
+	if digest == "" {
+		digest, err = impl.resolvePushedDigest(ctx, deployConfig, event.Tag)
+		if err != nil {
+			return report, false, err
+		}
+	}
+	report.Tags = append(report.Tags, event.Tag)
+	report.DiscoveredDigests[digest] = event.Tag
+	if !event.PushedOn.IsZero() {
+		report.PushedOn[digest] = event.PushedOn
+	}
+	host := deployConfig.RegistryURL
+	if host == "" {
+		host = event.RegistryHost
+	}
+	if impl.SCSconfig.DryRun {
+		ctx = bean.ContextWithDryRun(ctx)
+	}
+	sourceKey := deployConfig.GetSourceKey()
+	if bean.IsDryRun(ctx) || impl.OwnsSource(sourceKey) {
+		unlock := impl.lockSource(sourceKey)
+		err = impl.commonService.ReportDiscoveredImages(ctx, deployConfig, host, report)
+		unlock()
+	} else {
+		err = impl.commonService.RecordDiscoveredImages(deployConfig, host, report.DiscoveredDigests, report.PushedOn)
+	}
+	if err != nil {
+		return report, false, err
+	}
+	status, err := impl.commonService.GetNotificationStatus(deployConfig, digest)
+	return report, status != repository.NotificationStatusPending, err
+}
+
+// resolvePushedDigest resolves the digest of the pushed tag from the registry of the source.
+func (impl *SourceControllerServiceImpl) resolvePushedDigest(ctx context.Context, deployConfig bean.DeployConfig, tag string) (string, error) {
+	if deployConfig.RegistryType == registry.REGISTRYTYPE_ECR {
+		return "", errors.New("the push event of an ecr source must carry the digest")
+	}
+	keychain, auth, err := oci.GetKeychainAndAuth(deployConfig.RegistryCredential)
+	if err != nil {
+		impl.logger.Errorw("error in getting registry credentials", "err", err, "repoName", deployConfig.RepoName, "externalCiId", deployConfig.ExternalCiId)
+		return "", err
+	}
+	ctx, cancel := impl.withTimeout(ctx, deployConfig)
+	defer cancel()
+	transport := impl.registryRateLimiter.Transport(remote.DefaultTransport.(*http.Transport).Clone())
+	opts := makeRemoteOptions(ctx, transport, keychain, auth, impl.SCSconfig.Insecure)
+	url, err := parseRepositoryURLInValidFormat(deployConfig.RegistryURL, deployConfig.RepoName)
+	if err != nil {
+		return "", invalidOCIURLError{err}
+	}
+	digest, err := crane.Digest(url+":"+tag, opts.craneOpts...)
+	if err != nil {
+		impl.logger.Errorw("error in getting digest for tag", "err", err, "url", url, "tag", tag)
+		return "", err
+	}
+	return digest, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/devtron-labs/source-controller/bean"
+	"github.com/devtron-labs/source-controller/registry"
+	"github.com/devtron-labs/source-controller/registry/ecr"
+	"go.uber.org/zap"
+	"testing"
+)
+
+// stubEcrService resolves the registry of the sources without access key, counting the calls.
+type stubEcrService struct {
+	ecr.ReconciliationEcrService
+	calls int
+}
+
+func (s *stubEcrService) ResolveRegistry(ctx context.Context, deployConfig bean.DeployConfig) (string, string, error) {
+	s.calls++
+	if deployConfig.AwsAccessKeyId != "" {
+		return "", "", errors.New("invalid security token")
+	}
+	registryId := deployConfig.AwsRegistryId
+	if registryId == "" {
+		registryId = "123456789012"
+	}
+	return registryId, "eu-west-1", nil
+}
+
+func Test_resolveEcrRegistries(t *testing.T) {
+	ecrService := &stubEcrService{}
+	impl := &SourceControllerServiceImpl{logger: zap.NewNop().Sugar(), reconciliationEcrService: ecrService}
+	deployConfigs := []bean.DeployConfig{
+		{ExternalCiId: 1, RepoName: "devtron/app", RegistryType: registry.REGISTRYTYPE_ECR},
+		{ExternalCiId: 2, RepoName: "devtron/app", RegistryType: registry.REGISTRYTYPE_ECR, EcrConfig: bean.EcrConfig{AwsRegistryId: "210987654321"}},
+		{ExternalCiId: 3, RepoName: "devtron/app", RegistryType: registry.REGISTRYTYPE_ECR, EcrConfig: bean.EcrConfig{AwsAccessKeyId: "revoked"}},
+		{ExternalCiId: 4, RepoName: "devtron/app", RegistryType: registry.REGISTRYTYPE_ECR, EcrConfig: bean.EcrConfig{AwsRegistryId: "111111111111", AwsRegion: "us-east-1"}},
+	}
+	for i := 0; i < 2; i++ {
+		resolved := impl.resolveEcrRegistries(context.Background(), deployConfigs)
+		matches := make([]bool, 0, len(resolved))
+		for _, deployConfig := range resolved {
+			matches = append(matches, deployConfig.MatchesRepository("123456789012.dkr.ecr.eu-west-1.amazonaws.com", "devtron/app"))
+		}
+		if !matches[0] || matches[1] || matches[2] || matches[3] {
+			t.Errorf("resolveEcrRegistries() sources matching the push of the account of the credentials = %v, want the first only", matches)
+		}
+		if resolved[1].AwsRegistryId != "210987654321" || resolved[1].AwsRegion != "eu-west-1" {
+			t.Errorf("resolveEcrRegistries() = %+v, want the region resolved and the registry id kept", resolved[1].EcrConfig)
+		}
+	}
+	// the unresolved source is tried again, the resolved ones are cached
+	if ecrService.calls != 4 {
+		t.Errorf("resolveEcrRegistries() resolved %d registries, want 4", ecrService.calls)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/devtron-labs/source-controller/bean"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type sourceSchedule struct {
+	nextRunOn time.Time
+	running   bool
+}
+
+// sourceScheduler tracks when each source is due, so that every source is reconciled
+// at its own interval and never while its previous reconciliation is still running.
+type sourceScheduler struct {
+	lock      sync.Mutex
+	schedules map[string]*sourceSchedule
+}
+
+func newSourceScheduler() *sourceScheduler {
+	return &sourceScheduler{schedules: make(map[string]*sourceSchedule)}
+}
+
+// takeDue returns the sources due at now and marks them running. A source seen for
+// the first time is due after a random part of its jitter, the suspended sources and
+// the ones no longer configured are forgotten.
+func (s *sourceScheduler) takeDue(deployConfigs []bean.DeployConfig, now time.Time, defaultJitter time.Duration) []bean.DeployConfig {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var due []bean.DeployConfig
+	configured := make(map[string]bool, len(deployConfigs))
+	for _, deployConfig := range deployConfigs {
+		if deployConfig.Suspend {
+			continue
+		}
+		sourceKey := deployConfig.GetSourceKey()
+		configured[sourceKey] = true
+		schedule, ok := s.schedules[sourceKey]
+		if !ok {
+			schedule = &sourceSchedule{nextRunOn: now.Add(getJitter(deployConfig.GetJitter(defaultJitter)))}
+			s.schedules[sourceKey] = schedule
+		}
+		if schedule.running || now.Before(schedule.nextRunOn) {
+			continue
+		}
+		schedule.running = true
+		due = append(due, deployConfig)
+	}
+	for sourceKey, schedule := range s.schedules {
+		if !configured[sourceKey] && !schedule.running {
+			delete(s.schedules, sourceKey)
+		}
+	}
+	return due
+}
+
+// done schedules the next reconciliation of the source an interval, plus jitter, after now,
+// and not before notBefore, the end of the backoff of its registry when it was throttled.
+func (s *sourceScheduler) done(deployConfig bean.DeployConfig, now time.Time, defaultInterval, defaultJitter time.Duration, notBefore time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	schedule, ok := s.schedules[deployConfig.GetSourceKey()]
+	if !ok {
+		return
+	}
+	schedule.running = false
+	schedule.nextRunOn = now.Add(deployConfig.GetInterval(defaultInterval) + getJitter(deployConfig.GetJitter(defaultJitter)))
+	if schedule.nextRunOn.Before(notBefore) {
+		schedule.nextRunOn = notBefore
+	}
+}
+
+func getJitter(maxJitter time.Duration) time.Duration {
+	if maxJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maxJitter) + 1))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/source-controller/bean"
+)
+
+func Test_sourceScheduler(t *testing.T) {
+	now := time.Now()
+	fast := bean.DeployConfig{ExternalCiId: 1, RepoName: "fast"}
+	fast.Interval = "1m"
+	slow := bean.DeployConfig{ExternalCiId: 2, RepoName: "slow"}
+	suspended := bean.DeployConfig{ExternalCiId: 3, RepoName: "suspended"}
+	suspended.Suspend = true
+	deployConfigs := []bean.DeployConfig{fast, slow, suspended}
+
+	scheduler := newSourceScheduler()
+	if due := scheduler.takeDue(deployConfigs, now, 0); len(due) != 2 {
+		t.Fatalf("expected the 2 sources not suspended to be due, got %d", len(due))
+	}
+	if due := scheduler.takeDue(deployConfigs, now, 0); len(due) != 0 {
+		t.Fatalf("expected no source due while running, got %d", len(due))
+	}
+	scheduler.done(fast, now, 5*time.Minute, 0, time.Time{})
+	scheduler.done(slow, now, 5*time.Minute, 0, time.Time{})
+
+	due := scheduler.takeDue(deployConfigs, now.Add(2*time.Minute), 0)
+	if len(due) != 1 || due[0].RepoName != "fast" {
+		t.Fatalf("expected only the source with an interval of 1m to be due, got %v", due)
+	}
+	if due := scheduler.takeDue(deployConfigs, now.Add(6*time.Minute), 0); len(due) != 1 || due[0].RepoName != "slow" {
+		t.Fatalf("expected the source with the default interval to be due, got %v", due)
+	}
+
+	scheduler.done(fast, now, 5*time.Minute, 0, time.Time{})
+	scheduler.takeDue([]bean.DeployConfig{slow}, now, 0)
+	if _, ok := scheduler.schedules[fast.GetSourceKey()]; ok {
+		t.Fatalf("expected the schedule of a source no longer configured to be forgotten")
+	}
+}
@@ -2,9 +2,9 @@ package sql
 
 import (
 	"github.com/caarlos0/env/v6"
+	"github.com/devtron-labs/source-controller/internal/util"
 	"github.com/go-pg/pg"
 	"go.uber.org/zap"
-	"reflect"
 )
 
 type Config struct {
@@ -15,6 +15,7 @@ type Config struct {
 	Database        string `env:"PG_DATABASE" envDefault:"orchestrator"`
 	ApplicationName string `env:"APP" envDefault:"source-controller"`
 	LogQuery        bool   `env:"PG_LOG_QUERY" envDefault:"true"`
+	RunMigrations   bool   `env:"PG_RUN_MIGRATIONS" envDefault:"true"`
 }
 
 func GetConfig() (*Config, error) {
@@ -37,10 +38,17 @@ func NewDbConnection(cfg *Config, logger *zap.SugaredLogger) (*pg.DB, error) {
 	_, err := dbConnection.QueryOne(&test, `SELECT 1`)
 
 	if err != nil {
-		logger.Errorw("error in connecting db ", "db", obfuscateSecretTags(cfg), "err", err)
+		logger.Errorw("error in connecting db ", "db", util.ObfuscateSecretTags(cfg), "err", err)
 		return nil, err
 	} else {
-		logger.Infow("connected with db", "db", obfuscateSecretTags(cfg))
+		logger.Infow("connected with db", "db", util.ObfuscateSecretTags(cfg))
+	}
+	if cfg.RunMigrations {
+		err = RunMigrations(dbConnection, logger)
+		if err != nil {
+			logger.Errorw("error in running db migrations", "err", err)
+			return nil, err
+		}
 	}
 	//--------------
 	//if cfg.LogQuery {
@@ -57,21 +65,6 @@ func NewDbConnection(cfg *Config, logger *zap.SugaredLogger) (*pg.DB, error) {
 	return dbConnection, err
 }
 
-func obfuscateSecretTags(cfg interface{}) interface{} {
-
-	cfgDpl := reflect.New(reflect.ValueOf(cfg).Elem().Type()).Interface()
-	cfgDplElm := reflect.ValueOf(cfgDpl).Elem()
-	t := cfgDplElm.Type()
-	for i := 0; i < t.NumField(); i++ {
-		if _, ok := t.Field(i).Tag.Lookup("secretData"); ok {
-			cfgDplElm.Field(i).SetString("********")
-		} else {
-			cfgDplElm.Field(i).Set(reflect.ValueOf(cfg).Elem().Field(i))
-		}
-	}
-	return cfgDpl
-}
-
 //TODO: call it from somewhere
 /*func closeConnection() error {
 	return dbConnection.Close()
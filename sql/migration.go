@@ -0,0 +1,101 @@
+package sql
+
+import (
+	"embed"
+	"fmt"
+	"github.com/go-pg/pg"
+	"go.uber.org/zap"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationLockId is the postgres advisory lock held while migrating, so that
+// replicas starting together do not apply the same migration twice.
+const migrationLockId = 7283401
+
+//go:embed migrations/*.up.sql
+var migrationFiles embed.FS
+
+type schemaMigration struct {
+	tableName struct{}  `sql:"source_controller_schema_migration" pg:",discard_unknown_columns"`
+	Version   int       `sql:"version,pk"`
+	Name      string    `sql:"name,notnull"`
+	AppliedOn time.Time `sql:"applied_on,notnull"`
+}
+
+type migration struct {
+	version int
+	name    string
+	query   string
+}
+
+// RunMigrations applies, in order of version, the migrations of the tables owned by
+// the source controller which are not applied yet. Migrations are read from the
+// embedded migrations/<version>_<name>.up.sql files.
+func RunMigrations(dbConnection *pg.DB, logger *zap.SugaredLogger) error {
+	migrations, err := readMigrations()
+	if err != nil {
+		return err
+	}
+	_, err = dbConnection.Exec(`CREATE TABLE IF NOT EXISTS public.source_controller_schema_migration
+		("version" integer NOT NULL, "name" varchar(250) NOT NULL, "applied_on" timestamptz NOT NULL, PRIMARY KEY ("version"))`)
+	if err != nil {
+		logger.Errorw("error in creating schema migration table", "err", err)
+		return err
+	}
+	return dbConnection.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLockId); err != nil {
+			return err
+		}
+		var applied []schemaMigration
+		if err := tx.Model(&applied).Select(); err != nil {
+			return err
+		}
+		appliedVersions := make(map[int]bool, len(applied))
+		for _, m := range applied {
+			appliedVersions[m.Version] = true
+		}
+		for _, m := range migrations {
+			if appliedVersions[m.version] {
+				continue
+			}
+			logger.Infow("applying migration", "version", m.version, "name", m.name)
+			if _, err := tx.Exec(m.query); err != nil {
+				logger.Errorw("error in applying migration", "version", m.version, "name", m.name, "err", err)
+				return err
+			}
+			if err := tx.Insert(&schemaMigration{Version: m.version, Name: m.name, AppliedOn: time.Now()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func readMigrations() ([]migration, error) {
+	fileNames, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]migration, 0, len(fileNames))
+	for _, file := range fileNames {
+		fileName := strings.TrimSuffix(file.Name(), ".up.sql")
+		version, name, _ := strings.Cut(fileName, "_")
+		versionNumber, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q: %w", file.Name(), err)
+		}
+		query, err := migrationFiles.ReadFile(path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: versionNumber, name: name, query: string(query)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.source_controller_discovered_image;
+
+DROP SEQUENCE IF EXISTS public.id_seq_source_controller_discovered_image;
@@ -0,0 +1,22 @@
+CREATE SEQUENCE IF NOT EXISTS id_seq_source_controller_discovered_image;
+
+CREATE TABLE IF NOT EXISTS public.source_controller_discovered_image
+(
+    "id"                    integer      NOT NULL DEFAULT nextval('id_seq_source_controller_discovered_image'::regclass),
+    "source_key"            varchar(500) NOT NULL,
+    "external_ci_id"        integer      NOT NULL,
+    "image"                 text         NOT NULL,
+    "tag"                   varchar(250) NOT NULL,
+    "digest"                varchar(100) NOT NULL,
+    "first_seen_on"         timestamptz  NOT NULL,
+    "last_seen_on"          timestamptz  NOT NULL,
+    "notification_status"   varchar(50)  NOT NULL,
+    "notification_attempts" integer      NOT NULL DEFAULT 0,
+    "notified_on"           timestamptz,
+    "last_error"            text,
+    PRIMARY KEY ("id"),
+    UNIQUE ("source_key", "digest")
+);
+
+CREATE INDEX IF NOT EXISTS idx_source_controller_discovered_image_status
+    ON public.source_controller_discovered_image ("source_key", "notification_status");
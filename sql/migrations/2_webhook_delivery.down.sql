@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.idx_source_controller_discovered_image_next_attempt;
+
+ALTER TABLE public.source_controller_discovered_image
+    DROP COLUMN IF EXISTS "next_attempt_on",
+    DROP COLUMN IF EXISTS "last_status_code";
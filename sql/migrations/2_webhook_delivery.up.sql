@@ -0,0 +1,6 @@
+ALTER TABLE public.source_controller_discovered_image
+    ADD COLUMN IF NOT EXISTS "next_attempt_on" timestamptz,
+    ADD COLUMN IF NOT EXISTS "last_status_code" integer;
+
+CREATE INDEX IF NOT EXISTS idx_source_controller_discovered_image_next_attempt
+    ON public.source_controller_discovered_image ("notification_status", "next_attempt_on");
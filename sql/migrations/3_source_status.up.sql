@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS public.source_controller_source_status
+(
+    "source_key"       varchar(500) NOT NULL,
+    "external_ci_id"   integer      NOT NULL,
+    "registry_url"     text,
+    "repo_name"        text,
+    "active"           boolean      NOT NULL DEFAULT true,
+    "last_attempt_on"  timestamptz,
+    "last_success_on"  timestamptz,
+    "last_duration_ms" bigint       NOT NULL DEFAULT 0,
+    "tag_count"        integer      NOT NULL DEFAULT 0,
+    "discovered_count" integer      NOT NULL DEFAULT 0,
+    "notified_count"   integer      NOT NULL DEFAULT 0,
+    "last_error"       text,
+    "updated_on"       timestamptz  NOT NULL,
+    PRIMARY KEY ("source_key")
+);
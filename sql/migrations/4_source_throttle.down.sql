@@ -0,0 +1,2 @@
+ALTER TABLE public.source_controller_source_status
+    DROP COLUMN IF EXISTS "throttled_until";
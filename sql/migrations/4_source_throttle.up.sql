@@ -0,0 +1,2 @@
+ALTER TABLE public.source_controller_source_status
+    ADD COLUMN IF NOT EXISTS "throttled_until" timestamptz;
@@ -0,0 +1,3 @@
+ALTER TABLE public.source_controller_discovered_image
+    DROP COLUMN IF EXISTS "pushed_on",
+    DROP COLUMN IF EXISTS "push_to_trigger_ms";
@@ -0,0 +1,3 @@
+ALTER TABLE public.source_controller_discovered_image
+    ADD COLUMN IF NOT EXISTS "pushed_on" timestamptz,
+    ADD COLUMN IF NOT EXISTS "push_to_trigger_ms" bigint;
@@ -0,0 +1,4 @@
+ALTER TABLE public.source_controller_source_status
+    DROP COLUMN IF EXISTS "reconciled_by";
+
+DROP TABLE IF EXISTS public.source_controller_replica;
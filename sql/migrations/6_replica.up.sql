@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.source_controller_replica
+(
+    "replica_id"   varchar(250) NOT NULL,
+    "started_on"   timestamptz  NOT NULL,
+    "heartbeat_on" timestamptz  NOT NULL,
+    PRIMARY KEY ("replica_id")
+);
+
+ALTER TABLE public.source_controller_source_status
+    ADD COLUMN IF NOT EXISTS "reconciled_by" varchar(250);
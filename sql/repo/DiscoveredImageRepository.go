@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/go-pg/pg"
+	"go.uber.org/zap"
+)
+
+// Notification states of a DiscoveredImage
+const (
+	NotificationStatusPending        = "pending"
+	NotificationStatusNotified       = "notified"
+	NotificationStatusAlreadyPresent = "already_present"
+	// NotificationStatusDeadLetter is set once the delivery failed permanently or
+	// exhausted its attempts, the image is only notified again when replayed.
+	NotificationStatusDeadLetter = "dead_letter"
+)
+
+// DiscoveredImage is an image digest observed in a source, along with the state
+// of its notification to the external ci webhook.
+type DiscoveredImage struct {
+	tableName            struct{}  `sql:"source_controller_discovered_image" pg:",discard_unknown_columns"`
+	Id                   int       `sql:"id,pk" json:"id"`
+	SourceKey            string    `sql:"source_key,notnull" json:"sourceKey"`
+	ExternalCiId         int       `sql:"external_ci_id,notnull" json:"externalCiId"`
+	Image                string    `sql:"image,notnull" json:"image"`
+	Tag                  string    `sql:"tag,notnull" json:"tag"`
+	Digest               string    `sql:"digest,notnull" json:"digest"`
+	FirstSeenOn          time.Time `sql:"first_seen_on,notnull" json:"firstSeenOn"`
+	LastSeenOn           time.Time `sql:"last_seen_on,notnull" json:"lastSeenOn"`
+	NotificationStatus   string    `sql:"notification_status,notnull" json:"notificationStatus"`
+	NotificationAttempts int       `sql:"notification_attempts,notnull" json:"notificationAttempts"`
+	NotifiedOn           time.Time `sql:"notified_on" json:"notifiedOn,omitempty"`
+	NextAttemptOn        time.Time `sql:"next_attempt_on" json:"nextAttemptOn,omitempty"`
+	LastStatusCode       int       `sql:"last_status_code" json:"lastStatusCode,omitempty"`
+	LastError            string    `sql:"last_error" json:"lastError,omitempty"`
+	// PushedOn is the push or creation time of the image, PushToTriggerMs the time from it
+	// to the acceptance of the webhook, set once notified when the push time is known
+	PushedOn        time.Time `sql:"pushed_on" json:"pushedOn,omitempty"`
+	PushToTriggerMs int64     `sql:"push_to_trigger_ms" json:"pushToTriggerMs,omitempty"`
+}
+
+type DiscoveredImageRepository interface {
+	Save(discoveredImage *DiscoveredImage) error
+	Update(discoveredImage *DiscoveredImage) error
+	FindBySourceKeyAndDigests(sourceKey string, digests []string) ([]*DiscoveredImage, error)
+	FindBySourceKeyAndStatus(sourceKey string, notificationStatus string) ([]*DiscoveredImage, error)
+	FindById(id int) (*DiscoveredImage, error)
+	FindByStatus(notificationStatus string) ([]*DiscoveredImage, error)
+	FindDueForNotification(sourceKey string, now time.Time) ([]*DiscoveredImage, error)
+	UpdateLastSeenOn(sourceKey string, digests []string, lastSeenOn time.Time) error
+	ClaimForNotification(discoveredImage *DiscoveredImage, now time.Time, claimUntil time.Time) (bool, error)
+}
+
+type DiscoveredImageRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewDiscoveredImageRepositoryImpl(dbConnection *pg.DB, logger *zap.SugaredLogger) *DiscoveredImageRepositoryImpl {
+	return &DiscoveredImageRepositoryImpl{dbConnection: dbConnection, logger: logger}
+}
+
+// Save inserts the image unless the digest is already recorded for the source, a push
+// event and the poll of the source may both discover it.
+func (impl DiscoveredImageRepositoryImpl) Save(discoveredImage *DiscoveredImage) error {
+	_, err := impl.dbConnection.Model(discoveredImage).
+		OnConflict("(source_key, digest) DO NOTHING").
+		Insert()
+	return err
+}
+
+func (impl DiscoveredImageRepositoryImpl) Update(discoveredImage *DiscoveredImage) error {
+	return impl.dbConnection.Update(discoveredImage)
+}
+
+func (impl DiscoveredImageRepositoryImpl) FindBySourceKeyAndDigests(sourceKey string, digests []string) ([]*DiscoveredImage, error) {
+	var discoveredImages []*DiscoveredImage
+	if len(digests) == 0 {
+		return discoveredImages, nil
+	}
+	err := impl.dbConnection.Model(&discoveredImages).
+		Where("source_key = ?", sourceKey).
+		Where("digest in (?)", pg.In(digests)).
+		Select()
+	return discoveredImages, err
+}
+
+func (impl DiscoveredImageRepositoryImpl) FindBySourceKeyAndStatus(sourceKey string, notificationStatus string) ([]*DiscoveredImage, error) {
+	var discoveredImages []*DiscoveredImage
+	err := impl.dbConnection.Model(&discoveredImages).
+		Where("source_key = ?", sourceKey).
+		Where("notification_status = ?", notificationStatus).
+		Order("first_seen_on ASC").
+		Select()
+	return discoveredImages, err
+}
+
+func (impl DiscoveredImageRepositoryImpl) FindById(id int) (*DiscoveredImage, error) {
+	discoveredImage := &DiscoveredImage{}
+	err := impl.dbConnection.Model(discoveredImage).
+		Where("id = ?", id).
+		Select()
+	return discoveredImage, err
+}
+
+func (impl DiscoveredImageRepositoryImpl) FindByStatus(notificationStatus string) ([]*DiscoveredImage, error) {
+	var discoveredImages []*DiscoveredImage
+	err := impl.dbConnection.Model(&discoveredImages).
+		Where("notification_status = ?", notificationStatus).
+		Order("id ASC").
+		Select()
+	return discoveredImages, err
+}
+
+// FindDueForNotification returns the pending images whose next delivery attempt is due,
+// of the given source or of every source when sourceKey is empty.
+func (impl DiscoveredImageRepositoryImpl) FindDueForNotification(sourceKey string, now time.Time) ([]*DiscoveredImage, error) {
+	var discoveredImages []*DiscoveredImage
+	query := impl.dbConnection.Model(&discoveredImages).
+		Where("notification_status = ?", NotificationStatusPending).
+		Where("next_attempt_on IS NULL OR next_attempt_on <= ?", now)
+	if sourceKey != "" {
+		query = query.Where("source_key = ?", sourceKey)
+	}
+	err := query.Order("first_seen_on ASC").Select()
+	return discoveredImages, err
+}
+
+func (impl DiscoveredImageRepositoryImpl) UpdateLastSeenOn(sourceKey string, digests []string, lastSeenOn time.Time) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	_, err := impl.dbConnection.Model((*DiscoveredImage)(nil)).
+		Set("last_seen_on = ?", lastSeenOn).
+		Where("source_key = ?", sourceKey).
+		Where("digest in (?)", pg.In(digests)).
+		Update()
+	return err
+}
+
+// ClaimForNotification reserves the pending image for a delivery attempt by moving its next
+// attempt to claimUntil, unless it was claimed or delivered since it was read. Concurrent
+// deliveries of an image thus call the webhook once, and the claim of a replica which
+// stopped during the delivery expires at claimUntil.
+func (impl DiscoveredImageRepositoryImpl) ClaimForNotification(discoveredImage *DiscoveredImage, now time.Time, claimUntil time.Time) (bool, error) {
+	result, err := impl.dbConnection.Model((*DiscoveredImage)(nil)).
+		Set("next_attempt_on = ?", claimUntil).
+		Where("id = ?", discoveredImage.Id).
+		Where("notification_status = ?", NotificationStatusPending).
+		Where("notification_attempts = ?", discoveredImage.NotificationAttempts).
+		Where("next_attempt_on IS NULL OR next_attempt_on <= ?", now).
+		Update()
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"github.com/go-pg/pg"
+	"go.uber.org/zap"
+)
+
+// ExternalCiSource is an active external ci pipeline of the orchestrator along with
+// the container registry and repository configured in the ci template of its app.
+type ExternalCiSource struct {
+	ExternalCiId       int    `sql:"external_ci_id"`
+	AppId              int    `sql:"app_id"`
+	RepoName           string `sql:"docker_repository"`
+	RegistryId         string `sql:"docker_registry_id"`
+	RegistryURL        string `sql:"registry_url"`
+	RegistryType       string `sql:"registry_type"`
+	Username           string `sql:"username"`
+	Password           string `sql:"password"`
+	AwsAccessKeyId     string `sql:"aws_accesskey_id"`
+	AwsSecretAccessKey string `sql:"aws_secret_accesskey"`
+	AwsRegion          string `sql:"aws_region"`
+}
+
+type ExternalCiSourceRepository interface {
+	FindAllActive() ([]*ExternalCiSource, error)
+}
+
+type ExternalCiSourceRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewExternalCiSourceRepositoryImpl(dbConnection *pg.DB, logger *zap.SugaredLogger) *ExternalCiSourceRepositoryImpl {
+	return &ExternalCiSourceRepositoryImpl{dbConnection: dbConnection, logger: logger}
+}
+
+func (impl ExternalCiSourceRepositoryImpl) FindAllActive() ([]*ExternalCiSource, error) {
+	var externalCiSources []*ExternalCiSource
+	query := "SELECT ecp.id AS external_ci_id, ecp.app_id, ct.docker_repository, ct.docker_registry_id," +
+		" das.registry_url, das.registry_type, das.username, das.password," +
+		" das.aws_accesskey_id, das.aws_secret_accesskey, das.aws_region" +
+		" FROM external_ci_pipeline ecp" +
+		" INNER JOIN ci_template ct ON ct.app_id = ecp.app_id AND ct.active = true" +
+		" INNER JOIN docker_artifact_store das ON das.id = ct.docker_registry_id AND das.active = true" +
+		" WHERE ecp.active = true AND ct.docker_repository <> ''" +
+		" ORDER BY ecp.id;"
+	_, err := impl.dbConnection.Query(&externalCiSources, query)
+	return externalCiSources, err
+}
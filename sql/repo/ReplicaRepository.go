@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/go-pg/pg"
+	"go.uber.org/zap"
+)
+
+// Replica is a member of the replicas sharing the sources, alive as long as it heartbeats.
+type Replica struct {
+	tableName   struct{}  `sql:"source_controller_replica" pg:",discard_unknown_columns"`
+	ReplicaId   string    `sql:"replica_id,pk" json:"replicaId"`
+	StartedOn   time.Time `sql:"started_on,notnull" json:"startedOn"`
+	HeartbeatOn time.Time `sql:"heartbeat_on,notnull" json:"heartbeatOn"`
+}
+
+type ReplicaRepository interface {
+	Heartbeat(replica *Replica) error
+	FindAlive(since time.Time) ([]*Replica, error)
+	DeleteStale(before time.Time) error
+	Delete(replicaId string) error
+}
+
+type ReplicaRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewReplicaRepositoryImpl(dbConnection *pg.DB, logger *zap.SugaredLogger) *ReplicaRepositoryImpl {
+	return &ReplicaRepositoryImpl{dbConnection: dbConnection, logger: logger}
+}
+
+// Heartbeat inserts the replica, or refreshes its heartbeat when already a member.
+func (impl ReplicaRepositoryImpl) Heartbeat(replica *Replica) error {
+	_, err := impl.dbConnection.Model(replica).
+		OnConflict("(replica_id) DO UPDATE").
+		Set("heartbeat_on = EXCLUDED.heartbeat_on").
+		Insert()
+	return err
+}
+
+func (impl ReplicaRepositoryImpl) FindAlive(since time.Time) ([]*Replica, error) {
+	var replicas []*Replica
+	err := impl.dbConnection.Model(&replicas).
+		Where("heartbeat_on >= ?", since).
+		Order("replica_id ASC").
+		Select()
+	return replicas, err
+}
+
+func (impl ReplicaRepositoryImpl) DeleteStale(before time.Time) error {
+	_, err := impl.dbConnection.Model((*Replica)(nil)).
+		Where("heartbeat_on < ?", before).
+		Delete()
+	return err
+}
+
+func (impl ReplicaRepositoryImpl) Delete(replicaId string) error {
+	_, err := impl.dbConnection.Model((*Replica)(nil)).
+		Where("replica_id = ?", replicaId).
+		Delete()
+	return err
+}
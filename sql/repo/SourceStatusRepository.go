@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/go-pg/pg"
+	"go.uber.org/zap"
+)
+
+// SourceStatus is the outcome of the last reconciliation of a configured source.
+type SourceStatus struct {
+	tableName       struct{}  `sql:"source_controller_source_status" pg:",discard_unknown_columns"`
+	SourceKey       string    `sql:"source_key,pk" json:"sourceKey"`
+	ExternalCiId    int       `sql:"external_ci_id,notnull" json:"externalCiId"`
+	RegistryURL     string    `sql:"registry_url" json:"registryUrl"`
+	RepoName        string    `sql:"repo_name" json:"repoName"`
+	Active          bool      `sql:"active,notnull" json:"active"`
+	LastAttemptOn   time.Time `sql:"last_attempt_on" json:"lastAttemptOn,omitempty"`
+	LastSuccessOn   time.Time `sql:"last_success_on" json:"lastSuccessOn,omitempty"`
+	LastDurationMs  int64     `sql:"last_duration_ms,notnull" json:"lastDurationMs"`
+	TagCount        int       `sql:"tag_count,notnull" json:"tagCount"`
+	DiscoveredCount int       `sql:"discovered_count,notnull" json:"discoveredCount"`
+	NotifiedCount   int       `sql:"notified_count,notnull" json:"notifiedCount"`
+	LastError       string    `sql:"last_error" json:"lastError,omitempty"`
+	// ThrottledUntil is the end of the backoff of the registry host when it throttled the last reconciliation
+	ThrottledUntil time.Time `sql:"throttled_until" json:"throttledUntil,omitempty"`
+	// ReconciledBy is the replica which ran the last reconciliation, Owner the one owning the source now
+	ReconciledBy string    `sql:"reconciled_by" json:"reconciledBy,omitempty"`
+	Owner        string    `sql:"-" json:"owner,omitempty"`
+	UpdatedOn    time.Time `sql:"updated_on,notnull" json:"updatedOn"`
+}
+
+type SourceStatusRepository interface {
+	Upsert(sourceStatus *SourceStatus) error
+	FindBySourceKey(sourceKey string) (*SourceStatus, error)
+	FindActive() ([]*SourceStatus, error)
+	FindActiveByExternalCiId(externalCiId int) ([]*SourceStatus, error)
+	DeactivateAllExcept(sourceKeys []string) error
+}
+
+type SourceStatusRepositoryImpl struct {
+	dbConnection *pg.DB
+	logger       *zap.SugaredLogger
+}
+
+func NewSourceStatusRepositoryImpl(dbConnection *pg.DB, logger *zap.SugaredLogger) *SourceStatusRepositoryImpl {
+	return &SourceStatusRepositoryImpl{dbConnection: dbConnection, logger: logger}
+}
+
+func (impl SourceStatusRepositoryImpl) Upsert(sourceStatus *SourceStatus) error {
+	_, err := impl.dbConnection.Model(sourceStatus).
+		OnConflict("(source_key) DO UPDATE").
+		Set("external_ci_id = EXCLUDED.external_ci_id").
+		Set("registry_url = EXCLUDED.registry_url").
+		Set("repo_name = EXCLUDED.repo_name").
+		Set("active = EXCLUDED.active").
+		Set("last_attempt_on = EXCLUDED.last_attempt_on").
+		Set("last_success_on = EXCLUDED.last_success_on").
+		Set("last_duration_ms = EXCLUDED.last_duration_ms").
+		Set("tag_count = EXCLUDED.tag_count").
+		Set("discovered_count = EXCLUDED.discovered_count").
+		Set("notified_count = EXCLUDED.notified_count").
+		Set("last_error = EXCLUDED.last_error").
+		Set("throttled_until = EXCLUDED.throttled_until").
+		Set("reconciled_by = EXCLUDED.reconciled_by").
+		Set("updated_on = EXCLUDED.updated_on").
+		Insert()
+	return err
+}
+
+func (impl SourceStatusRepositoryImpl) FindBySourceKey(sourceKey string) (*SourceStatus, error) {
+	sourceStatus := &SourceStatus{}
+	err := impl.dbConnection.Model(sourceStatus).
+		Where("source_key = ?", sourceKey).
+		Select()
+	return sourceStatus, err
+}
+
+func (impl SourceStatusRepositoryImpl) FindActive() ([]*SourceStatus, error) {
+	var sourceStatuses []*SourceStatus
+	err := impl.dbConnection.Model(&sourceStatuses).
+		Where("active = ?", true).
+		Order("external_ci_id ASC").
+		Select()
+	return sourceStatuses, err
+}
+
+func (impl SourceStatusRepositoryImpl) FindActiveByExternalCiId(externalCiId int) ([]*SourceStatus, error) {
+	var sourceStatuses []*SourceStatus
+	err := impl.dbConnection.Model(&sourceStatuses).
+		Where("active = ?", true).
+		Where("external_ci_id = ?", externalCiId).
+		Select()
+	return sourceStatuses, err
+}
+
+// DeactivateAllExcept marks the sources no longer configured as inactive, keeping their last status.
+func (impl SourceStatusRepositoryImpl) DeactivateAllExcept(sourceKeys []string) error {
+	query := impl.dbConnection.Model((*SourceStatus)(nil)).
+		Set("active = ?", false).
+		Set("updated_on = ?", time.Now()).
+		Where("active = ?", true)
+	if len(sourceKeys) > 0 {
+		query = query.Where("source_key not in (?)", pg.In(sourceKeys))
+	}
+	_, err := query.Update()
+	return err
+}
@@ -8,7 +8,11 @@ package main
 
 import (
 	"github.com/devtron-labs/source-controller/api"
+	"github.com/devtron-labs/source-controller/common"
 	"github.com/devtron-labs/source-controller/internal/logger"
+	"github.com/devtron-labs/source-controller/registry"
+	"github.com/devtron-labs/source-controller/registry/ecr"
+	"github.com/devtron-labs/source-controller/registry/gcr"
 	"github.com/devtron-labs/source-controller/sql"
 	"github.com/devtron-labs/source-controller/sql/repo"
 )
@@ -25,13 +29,54 @@ func InitializeApp() (*App, error) {
 	if err != nil {
 		return nil, err
 	}
-	router := api.NewRouter(sugaredLogger)
+	ciArtifactRepositoryImpl := repository.NewCiArtifactRepositoryImpl(db, sugaredLogger)
+	discoveredImageRepositoryImpl := repository.NewDiscoveredImageRepositoryImpl(db, sugaredLogger)
+	replicaRepositoryImpl := repository.NewReplicaRepositoryImpl(db, sugaredLogger)
+	leaderElectionServiceImpl, err := common.NewLeaderElectionServiceImpl(sugaredLogger, db)
+	if err != nil {
+		return nil, err
+	}
+	sourceShardServiceImpl, err := common.NewSourceShardServiceImpl(sugaredLogger, replicaRepositoryImpl, leaderElectionServiceImpl)
+	if err != nil {
+		return nil, err
+	}
+	commonServiceImpl := common.NewCommonServiceImpl(sugaredLogger, ciArtifactRepositoryImpl, discoveredImageRepositoryImpl, sourceShardServiceImpl)
+	webhookDeliveryRestHandlerImpl := api.NewWebhookDeliveryRestHandlerImpl(sugaredLogger, commonServiceImpl)
+	sourceStatusRepositoryImpl := repository.NewSourceStatusRepositoryImpl(db, sugaredLogger)
+	sourceStatusServiceImpl := common.NewSourceStatusServiceImpl(sugaredLogger, sourceStatusRepositoryImpl, sourceShardServiceImpl)
+	sourceStatusRestHandlerImpl := api.NewSourceStatusRestHandlerImpl(sugaredLogger, sourceStatusServiceImpl)
 	sourceControllerConfig, err := GetSourceControllerConfig()
 	if err != nil {
 		return nil, err
 	}
-	ciArtifactRepositoryImpl := repository.NewCiArtifactRepositoryImpl(db, sugaredLogger)
-	sourceControllerServiceImpl := NewSourceControllerServiceImpl(sugaredLogger, sourceControllerConfig, ciArtifactRepositoryImpl)
-	app := NewApp(sugaredLogger, db, router, sourceControllerServiceImpl)
+	registryRateLimiterImpl, err := registry.NewRegistryRateLimiterImpl(sugaredLogger)
+	if err != nil {
+		return nil, err
+	}
+	reconciliationEcrServiceImpl := ecr.NewReconciliationServiceImpl(sugaredLogger, ciArtifactRepositoryImpl, commonServiceImpl, registryRateLimiterImpl)
+	externalCiSourceRepositoryImpl := repository.NewExternalCiSourceRepositoryImpl(db, sugaredLogger)
+	externalCiSourceServiceImpl, err := common.NewExternalCiSourceServiceImpl(sugaredLogger, externalCiSourceRepositoryImpl)
+	if err != nil {
+		return nil, err
+	}
+	sourceControllerServiceImpl := NewSourceControllerServiceImpl(sugaredLogger, sourceControllerConfig, ciArtifactRepositoryImpl, commonServiceImpl, reconciliationEcrServiceImpl, sourceStatusServiceImpl, externalCiSourceServiceImpl, registryRateLimiterImpl, sourceShardServiceImpl)
+	reconcileRestHandlerImpl := api.NewReconcileRestHandlerImpl(sugaredLogger, sourceControllerServiceImpl)
+	pushEventRestHandlerImpl, err := api.NewPushEventRestHandlerImpl(sugaredLogger, sourceControllerServiceImpl)
+	if err != nil {
+		return nil, err
+	}
+	router, err := api.NewRouter(sugaredLogger, webhookDeliveryRestHandlerImpl, sourceStatusRestHandlerImpl, reconcileRestHandlerImpl, pushEventRestHandlerImpl)
+	if err != nil {
+		return nil, err
+	}
+	ecrPushEventConsumerImpl, err := ecr.NewEcrPushEventConsumerImpl(sugaredLogger, sourceControllerServiceImpl)
+	if err != nil {
+		return nil, err
+	}
+	gcrPushEventConsumerImpl, err := gcr.NewGcrPushEventConsumerImpl(sugaredLogger, sourceControllerServiceImpl)
+	if err != nil {
+		return nil, err
+	}
+	app := NewApp(sugaredLogger, db, router, sourceControllerServiceImpl, commonServiceImpl, leaderElectionServiceImpl, sourceShardServiceImpl, ecrPushEventConsumerImpl, gcrPushEventConsumerImpl)
 	return app, nil
 }